@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strconv"
+)
+
+// Upper bounds (in seconds) and names of the age buckets, in which the instances are counted. Older instances fall into the last bucket
+var ageBucketBounds = []int64{60, 5 * 60, 30 * 60}
+var ageBucketNames = []string{"lt_1m", "1m_5m", "5m_30m", "gt_30m"}
+
+type AgeDistribution struct {
+	Metric         string                    `json:"metric"`
+	Instances      int                       `json:"instances"`
+	Buckets        map[string]int            `json:"buckets"`
+	ComponentTypes map[string]map[string]int `json:"component_types"`
+	Message        string                    `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that counts the instances of a cycle per age bucket, broken down by component type.
+// Many instances in the youngest bucket indicate heavy scaling or churn. Instances of unknown age are not counted
+func getAgeDistribution(instanceStats []InstanceResourceStats) AgeDistribution {
+	distribution := AgeDistribution{
+		Metric:         "age-distribution",
+		RecordIdentity: recordIdentity,
+		Buckets:        newAgeBuckets(),
+		ComponentTypes: map[string]map[string]int{},
+	}
+	for _, stats := range instanceStats {
+		if stats.CreationTimestamp == "" {
+			continue
+		}
+
+		typeBuckets, ok := distribution.ComponentTypes[stats.ComponentType]
+		if !ok {
+			typeBuckets = newAgeBuckets()
+			distribution.ComponentTypes[stats.ComponentType] = typeBuckets
+		}
+
+		bucket := getAgeBucket(stats.AgeSeconds)
+		distribution.Instances++
+		distribution.Buckets[bucket]++
+		typeBuckets[bucket]++
+	}
+
+	distribution.Message = "Age distribution of " + strconv.Itoa(distribution.Instances) + " instances: "
+	for i, name := range ageBucketNames {
+		if i > 0 {
+			distribution.Message += ", "
+		}
+		distribution.Message += strconv.Itoa(distribution.Buckets[name]) + " " + name
+	}
+	return distribution
+}
+
+// Helper function that creates a bucket map, in which every bucket is present, even if it is empty
+func newAgeBuckets() map[string]int {
+	buckets := map[string]int{}
+	for _, name := range ageBucketNames {
+		buckets[name] = 0
+	}
+	return buckets
+}
+
+// Helper function that determines the name of the bucket, into which an instance of the given age falls
+func getAgeBucket(ageSeconds int64) string {
+	for i, bound := range ageBucketBounds {
+		if ageSeconds < bound {
+			return ageBucketNames[i]
+		}
+	}
+	return ageBucketNames[len(ageBucketNames)-1]
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Usage thresholds (in percent) above which a resource alert is written. A threshold of 0 disables the alert
+var cpuAlertPercent int64 = 0
+var memoryAlertPercent int64 = 0
+
+type ResourceAlert struct {
+	Metric        string `json:"metric"`
+	Resource      string `json:"resource"`
+	Usage         int64  `json:"usage"`
+	Threshold     int64  `json:"threshold"`
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	Parent        string `json:"parent"`
+	ComponentType string `json:"component_type"`
+	ComponentName string `json:"component_name"`
+	Message       string `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that determines the alerts of all instances whose CPU or memory usage reached the configured thresholds
+func getResourceAlerts(instanceStats []InstanceResourceStats) []ResourceAlert {
+	alerts := []ResourceAlert{}
+	for _, stats := range instanceStats {
+		if cpuAlertPercent > 0 && stats.Cpu.Usage >= cpuAlertPercent {
+			alerts = append(alerts, newResourceAlert(stats, "cpu", stats.Cpu.Usage, cpuAlertPercent))
+		}
+		if memoryAlertPercent > 0 && stats.Memory.Usage >= memoryAlertPercent {
+			alerts = append(alerts, newResourceAlert(stats, "memory", stats.Memory.Usage, memoryAlertPercent))
+		}
+	}
+	return alerts
+}
+
+func newResourceAlert(stats InstanceResourceStats, resourceName string, usage int64, threshold int64) ResourceAlert {
+	return ResourceAlert{
+		Metric:         "resource-alert",
+		RecordIdentity: recordIdentity,
+		Resource:       resourceName,
+		Usage:          usage,
+		Threshold:      threshold,
+		Namespace:      stats.Namespace,
+		Name:           stats.Name,
+		Parent:         stats.Parent,
+		ComponentType:  stats.ComponentType,
+		ComponentName:  stats.ComponentName,
+		Message:        "The " + resourceName + " usage of " + stats.ComponentType + " instance '" + stats.Name + "' is at " + fmt.Sprintf("%d", usage) + "%, which reaches the threshold of " + fmt.Sprintf("%d", threshold) + "%",
+	}
+}
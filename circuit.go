@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// Number of consecutive failed cycles, after which the collection interval is backed off. A threshold of 0 disables the circuit breaker
+var circuitFailureThreshold = 3
+
+// Upper bound of the backed off collection interval
+var circuitMaxInterval = 10 * time.Minute
+
+// Backs off the collection, while the Kube API or the metrics API keep failing, to not add load during an outage
+type CircuitBreaker struct {
+	consecutiveFailures int
+	open                bool
+}
+
+type CircuitState struct {
+	Metric              string `json:"metric"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	IntervalSeconds     int64  `json:"interval_seconds"`
+	Message             string `json:"message"`
+	RecordIdentity
+}
+
+// RecordResult tracks the outcome of a cycle. Once the circuit opens or closes again, a record is written
+func (c *CircuitBreaker) RecordResult(success bool, interval time.Duration) {
+	if success {
+		failures := c.consecutiveFailures
+		c.consecutiveFailures = 0
+		if c.open {
+			c.open = false
+			c.writeState(failures, interval, "Collection succeeded again after "+strconv.Itoa(failures)+" failed cycles, resuming the regular interval of "+interval.String())
+		}
+		return
+	}
+
+	c.consecutiveFailures++
+	if !c.open && circuitFailureThreshold > 0 && c.consecutiveFailures >= circuitFailureThreshold {
+		c.open = true
+		c.writeState(c.consecutiveFailures, interval, "Collection failed "+strconv.Itoa(c.consecutiveFailures)+" consecutive cycles, backing off the interval up to "+circuitMaxInterval.String())
+	}
+}
+
+// Interval returns the interval until the next cycle. While the circuit is open, the given interval doubles with each further failure up to the cap
+func (c *CircuitBreaker) Interval(interval time.Duration) time.Duration {
+	if !c.open {
+		return interval
+	}
+
+	maxInterval := max(circuitMaxInterval, interval)
+	backoff := interval
+	for i := circuitFailureThreshold; i <= c.consecutiveFailures && backoff < maxInterval; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxInterval)
+}
+
+// Helper function that writes the current state of the circuit along with the interval until the next cycle
+func (c *CircuitBreaker) writeState(failures int, interval time.Duration, message string) {
+	state := "closed"
+	if c.open {
+		state = "open"
+	}
+	writeRecord(CircuitState{
+		Metric:              "circuit-state",
+		RecordIdentity:      recordIdentity,
+		State:               state,
+		ConsecutiveFailures: failures,
+		IntervalSeconds:     int64(c.Interval(interval).Seconds()),
+		Message:             message,
+	})
+	flushOutput()
+}
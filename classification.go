@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Rule that classifies all pods carrying the given label as the given component type.
+// The component name and the parent are read from the name and parent labels. If the name label is absent,
+// the component is considered to be 'standalone'
+type ClassificationRule struct {
+	Label         string `json:"label"`
+	ComponentType string `json:"component_type"`
+	NameLabel     string `json:"name_label"`
+	ParentLabel   string `json:"parent_label"`
+}
+
+// Rules that classify Code Engine builds, apps and jobs. The first matching rule wins
+var builtinClassificationRules = []ClassificationRule{
+	{Label: "buildrun.shipwright.io/name", ComponentType: "build", NameLabel: "build.shipwright.io/name", ParentLabel: "buildrun.shipwright.io/name"},
+	{Label: "build.shipwright.io/name", ComponentType: "build", NameLabel: "build.shipwright.io/name", ParentLabel: "buildrun.shipwright.io/name"},
+	{Label: "serving.knative.dev/service", ComponentType: "app", NameLabel: "serving.knative.dev/service", ParentLabel: "serving.knative.dev/revision"},
+	{Label: "codeengine.cloud.ibm.com/job-run", ComponentType: "job", NameLabel: "codeengine.cloud.ibm.com/job-definition-name", ParentLabel: "codeengine.cloud.ibm.com/job-run"},
+}
+
+// Rules that have been configured by the user. They take precedence over the built-in rules
+var customClassificationRules []ClassificationRule
+
+// Helper function to parse and validate a JSON array of classification rules
+func parseClassificationRules(value string) ([]ClassificationRule, error) {
+	rules := []ClassificationRule{}
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range rules {
+		if len(rule.Label) == 0 {
+			return nil, errors.New("rule " + strconv.Itoa(i) + " does not specify a label")
+		}
+		if parseComponentType(rule.ComponentType) == Unknown {
+			return nil, errors.New("rule " + strconv.Itoa(i) + " specifies the unsupported component type '" + rule.ComponentType + "'")
+		}
+	}
+
+	return rules, nil
+}
+
+// Helper function to determine the component type, the component name and the parent
+// (revision, job run or build run) based on the labels of a pod
+func classifyPod(labels map[string]string) (ComponentType, string, string) {
+	for _, rules := range [][]ClassificationRule{customClassificationRules, builtinClassificationRules} {
+		for _, rule := range rules {
+			if _, ok := labels[rule.Label]; !ok {
+				continue
+			}
+
+			componentType := parseComponentType(rule.ComponentType)
+			parent := labels[rule.ParentLabel]
+			componentName := "standalone"
+			if val, ok := labels[rule.NameLabel]; ok {
+				componentName = val
+			} else if buildName, ok := getBuildNameFromBuildRun(parent); ok && componentType == Build {
+				componentName = buildName
+			}
+			return componentType, componentName, parent
+		}
+	}
+
+	return Unknown, "unknown", ""
+}
+
+// Helper function to derive the name of a build from the name of one of its build runs.
+// Build runs of a build are named '<build-name>-run-<suffix>'
+func getBuildNameFromBuildRun(buildRunName string) (string, bool) {
+	i := strings.LastIndex(buildRunName, "-run-")
+	if i <= 0 {
+		return "", false
+	}
+	return buildRunName[:i], true
+}
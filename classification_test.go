@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestClassifyPod(t *testing.T) {
+	tests := []struct {
+		name          string
+		labels        map[string]string
+		customRules   []ClassificationRule
+		componentType ComponentType
+		componentName string
+		parent        string
+	}{
+		{
+			name: "app",
+			labels: map[string]string{
+				"serving.knative.dev/service":  "my-app",
+				"serving.knative.dev/revision": "my-app-00001",
+			},
+			componentType: App,
+			componentName: "my-app",
+			parent:        "my-app-00001",
+		},
+		{
+			name: "job",
+			labels: map[string]string{
+				"codeengine.cloud.ibm.com/job-run":             "my-job-run",
+				"codeengine.cloud.ibm.com/job-definition-name": "my-job",
+			},
+			componentType: Job,
+			componentName: "my-job",
+			parent:        "my-job-run",
+		},
+		{
+			name: "standalone job run",
+			labels: map[string]string{
+				"codeengine.cloud.ibm.com/job-run": "my-job-run",
+			},
+			componentType: Job,
+			componentName: "standalone",
+			parent:        "my-job-run",
+		},
+		{
+			name: "build",
+			labels: map[string]string{
+				"buildrun.shipwright.io/name": "my-build-run-abcde",
+				"build.shipwright.io/name":    "my-build",
+			},
+			componentType: Build,
+			componentName: "my-build",
+			parent:        "my-build-run-abcde",
+		},
+		{
+			name: "build run without build label",
+			labels: map[string]string{
+				"buildrun.shipwright.io/name": "my-build-run-abcde",
+			},
+			componentType: Build,
+			componentName: "my-build",
+			parent:        "my-build-run-abcde",
+		},
+		{
+			name: "build without build run label",
+			labels: map[string]string{
+				"build.shipwright.io/name": "my-build",
+			},
+			componentType: Build,
+			componentName: "my-build",
+			parent:        "",
+		},
+		{
+			name: "build run without build label and run suffix",
+			labels: map[string]string{
+				"buildrun.shipwright.io/name": "my-build-abcde",
+			},
+			componentType: Build,
+			componentName: "standalone",
+			parent:        "my-build-abcde",
+		},
+		{
+			name:          "unknown",
+			labels:        map[string]string{"app": "something-else"},
+			componentType: Unknown,
+			componentName: "unknown",
+			parent:        "",
+		},
+		{
+			name:          "no labels",
+			labels:        nil,
+			componentType: Unknown,
+			componentName: "unknown",
+			parent:        "",
+		},
+		{
+			name: "custom rule",
+			labels: map[string]string{
+				"example.com/worker":   "my-worker",
+				"example.com/instance": "my-worker-1",
+			},
+			customRules:   []ClassificationRule{{Label: "example.com/worker", ComponentType: "job", NameLabel: "example.com/worker", ParentLabel: "example.com/instance"}},
+			componentType: Job,
+			componentName: "my-worker",
+			parent:        "my-worker-1",
+		},
+		{
+			name: "standalone custom rule",
+			labels: map[string]string{
+				"example.com/worker": "",
+			},
+			customRules:   []ClassificationRule{{Label: "example.com/worker", ComponentType: "job", NameLabel: "example.com/name", ParentLabel: "example.com/instance"}},
+			componentType: Job,
+			componentName: "standalone",
+			parent:        "",
+		},
+		{
+			name: "custom rule before built-in rule",
+			labels: map[string]string{
+				"serving.knative.dev/service":  "my-app",
+				"serving.knative.dev/revision": "my-app-00001",
+				"example.com/batch":            "my-batch",
+			},
+			customRules:   []ClassificationRule{{Label: "example.com/batch", ComponentType: "job", NameLabel: "example.com/batch", ParentLabel: "serving.knative.dev/revision"}},
+			componentType: Job,
+			componentName: "my-batch",
+			parent:        "my-app-00001",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			customClassificationRules = tt.customRules
+			defer func() { customClassificationRules = nil }()
+
+			componentType, componentName, parent := classifyPod(tt.labels)
+			if componentType != tt.componentType || componentName != tt.componentName || parent != tt.parent {
+				t.Errorf("classifyPod() = (%s, %s, %s), want (%s, %s, %s)", componentType, componentName, parent, tt.componentType, tt.componentName, tt.parent)
+			}
+		})
+	}
+}
+
+func TestGetBuildNameFromBuildRun(t *testing.T) {
+	tests := []struct {
+		buildRunName string
+		buildName    string
+		ok           bool
+	}{
+		{buildRunName: "my-build-run-abcde", buildName: "my-build", ok: true},
+		{buildRunName: "my-run-build-run-abcde", buildName: "my-run-build", ok: true},
+		{buildRunName: "my-build-abcde", buildName: "", ok: false},
+		{buildRunName: "-run-abcde", buildName: "", ok: false},
+		{buildRunName: "", buildName: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		buildName, ok := getBuildNameFromBuildRun(tt.buildRunName)
+		if buildName != tt.buildName || ok != tt.ok {
+			t.Errorf("getBuildNameFromBuildRun(%q) = (%q, %t), want (%q, %t)", tt.buildRunName, buildName, ok, tt.buildName, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Helper function that reads the given YAML or JSON config file, whose keys are the names of the env vars
+// (e.g. 'INTERVAL: 30'). Each value is applied as env var, unless that env var is already set, such that
+// env vars override the file. Lists are joined with commas. The names of all keys of the file are returned
+func loadConfigFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file '%s': %w", path, err)
+	}
+
+	keys := []string{}
+	for key, value := range values {
+		var text string
+		switch v := value.(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			return nil, fmt.Errorf("invalid value of '%s' in config file '%s', expected a scalar or a list", key, path)
+		case []interface{}:
+			items := []string{}
+			for _, item := range v {
+				items = append(items, formatConfigValue(item))
+			}
+			text = strings.Join(items, ",")
+		default:
+			text = formatConfigValue(v)
+		}
+
+		keys = append(keys, key)
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, text)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Helper function that formats a scalar value of the config file. Numbers are parsed as float64, which are formatted
+// without exponent, such that e.g. '1000000' is not turned into '1e+06'
+func formatConfigValue(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// Helper function that logs the effective values of the given config keys once, masking credentials
+func logEffectiveConfig(keys []string) {
+	attrs := []interface{}{}
+	for _, key := range keys {
+		value := os.Getenv(key)
+		if strings.Contains(key, "TOKEN") || strings.Contains(key, "SECRET") || strings.Contains(key, "PASSWORD") || strings.Contains(key, "API_KEY") {
+			value = "***"
+		}
+		attrs = append(attrs, key, value)
+	}
+	logger.Info("Effective configuration", attrs...)
+}
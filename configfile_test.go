@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFileFormatsNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("TEST_MAX_BYTES: 1000000\nTEST_RATIO: 0.25\nTEST_PORTS: [8080, 10000000]\n"), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	for _, key := range []string{"TEST_MAX_BYTES", "TEST_RATIO", "TEST_PORTS"} {
+		defer os.Unsetenv(key)
+	}
+
+	if _, err := loadConfigFile(path); err != nil {
+		t.Fatalf("loadConfigFile() failed: %v", err)
+	}
+	want := map[string]string{"TEST_MAX_BYTES": "1000000", "TEST_RATIO": "0.25", "TEST_PORTS": "8080,10000000"}
+	for key, value := range want {
+		if got := os.Getenv(key); got != value {
+			t.Errorf("got %s=%q, want %q", key, got, value)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// Whether an additional record is written for each container of an instance
+var containerDetail = false
+
+type ContainerResourceStats struct {
+	Metric        string        `json:"metric"`
+	Namespace     string        `json:"namespace"`
+	Name          string        `json:"name"`
+	Container     string        `json:"container"`
+	IsInit        bool          `json:"is_init"`
+	ComponentType string        `json:"component_type"`
+	ComponentName string        `json:"component_name"`
+	QosClass      string        `json:"qos_class,omitempty"`
+	Cpu           ResourceStats `json:"cpu"`
+	Memory        ResourceStats `json:"memory"`
+	Message       string        `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that captures the usage and limits of each container of an instance.
+// If the pod could not be found, only the usage is reported
+func getContainerStats(podMetric v1beta1.PodMetrics, pod *v1.Pod, componentType ComponentType, componentName string) []ContainerResourceStats {
+	containerStats := []ContainerResourceStats{}
+	for _, container := range podMetric.Containers {
+		cpu, memory, _ := getCpuMemoryAndStorageUsage(container.Name, podMetric)
+		cpuCurrent := cpu.ToDec().AsApproximateFloat64() * 1000
+		memoryCurrent := getMemoryInUnit(memory)
+
+		stats := ContainerResourceStats{
+			Metric:         "container-resources",
+			RecordIdentity: recordIdentity,
+			Namespace:      podMetric.Namespace,
+			Name:           podMetric.Name,
+			Container:      container.Name,
+			ComponentType:  componentType.String(),
+			ComponentName:  componentName,
+			Cpu:            ResourceStats{Current: int64(cpuCurrent), CurrentRaw: getRawQuantity(cpu), currentExact: cpuCurrent},
+			Memory:         ResourceStats{Current: int64(memoryCurrent), CurrentRaw: getRawQuantity(memory), currentExact: memoryCurrent},
+		}
+
+		if pod != nil {
+			stats.QosClass = string(pod.Status.QOSClass)
+
+			// init containers are reported alongside the main containers, hence tell them apart to not misattribute their usage
+			limitsPod := *pod
+			if initContainer := getInitContainer(container.Name, *pod); initContainer != nil {
+				stats.IsInit = true
+				limitsPod.Spec.Containers = []v1.Container{*initContainer}
+			}
+			cpuLimitQuantity, memoryLimitQuantity, _ := getCpuMemoryAndStorageLimits(container.Name, limitsPod)
+
+			cpuLimit := cpuLimitQuantity.ToDec().AsApproximateFloat64() * 1000
+			stats.Cpu.Configured = int64(cpuLimit)
+			stats.Cpu.ConfiguredRaw = getRawQuantity(cpuLimitQuantity)
+			stats.Cpu.configuredExact = cpuLimit
+			stats.Cpu.Usage = getUsagePercent(cpuCurrent, cpuLimit)
+
+			memoryLimit := getMemoryInUnit(memoryLimitQuantity)
+			stats.Memory.Configured = int64(memoryLimit)
+			stats.Memory.ConfiguredRaw = getRawQuantity(memoryLimitQuantity)
+			stats.Memory.configuredExact = memoryLimit
+			stats.Memory.Usage = getBytesUsagePercent(memory.Value(), memoryLimitQuantity.Value())
+		}
+
+		containerKind := "container"
+		if stats.IsInit {
+			containerKind = "init container"
+		}
+		stats.Message = "Captured metrics of " + containerKind + " '" + stats.Container + "' of " + stats.ComponentType + " instance '" + stats.Name + "': " + fmt.Sprintf("%d", stats.Cpu.Current) + "m vCPU, " + fmt.Sprintf("%d", stats.Memory.Current) + " " + memoryUnit.name + " memory"
+		containerStats = append(containerStats, stats)
+	}
+	return containerStats
+}
+
+// Helper function that returns the init container with the given name, or nil if the pod has no such init container
+func getInitContainer(containerName string, pod v1.Pod) *v1.Container {
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == containerName {
+			return &pod.Spec.InitContainers[i]
+		}
+	}
+	return nil
+}
+
+// Helper function that removes the metrics of the init containers of the given pod, such that their usage is not accounted
+// against the limits of the main containers. If the pod could not be found, the metric is returned unchanged
+func withoutInitContainers(podMetric v1beta1.PodMetrics, pod *v1.Pod) v1beta1.PodMetrics {
+	if pod == nil || len(pod.Spec.InitContainers) == 0 {
+		return podMetric
+	}
+
+	filtered := podMetric
+	filtered.Containers = []v1beta1.ContainerMetrics{}
+	for _, container := range podMetric.Containers {
+		if getInitContainer(container.Name, *pod) == nil {
+			filtered.Containers = append(filtered.Containers, container)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func TestInitContainersAreNotAccountedToTheInstance(t *testing.T) {
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		InitContainers: []v1.Container{{Name: "init"}},
+		Containers: []v1.Container{{Name: "main", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+			v1.ResourceCPU: resource.MustParse("1"),
+		}}}},
+	}}
+	podMetric := v1beta1.PodMetrics{Containers: []v1beta1.ContainerMetrics{
+		{Name: "main", Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}},
+		{Name: "init", Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse("900m")}},
+	}}
+
+	cpu, _, _ := getCpuMemoryAndStorageUsage("", withoutInitContainers(podMetric, pod))
+	if cpu.MilliValue() != 100 {
+		t.Errorf("cpu usage = %dm, want 100m", cpu.MilliValue())
+	}
+
+	// without the pod, the init containers cannot be told apart
+	cpu, _, _ = getCpuMemoryAndStorageUsage("", withoutInitContainers(podMetric, nil))
+	if cpu.MilliValue() != 1000 {
+		t.Errorf("cpu usage without pod = %dm, want 1000m", cpu.MilliValue())
+	}
+
+	// the per-container records still report the init container, flagged as such
+	containerStats := getContainerStats(podMetric, pod, App, "my-app")
+	if len(containerStats) != 2 || containerStats[0].IsInit || !containerStats[1].IsInit {
+		t.Errorf("unexpected container stats %+v", containerStats)
+	}
+}
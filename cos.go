@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Configuration of the IBM Cloud Object Storage (or any other S3-compatible) bucket, to which batches of cycles are uploaded
+type COSConfig struct {
+	endpoint        string
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	prefix          string
+	batchCycles     int
+	flushInterval   time.Duration
+	maxBufferLines  int
+}
+
+// Buffers the instances of multiple cycles as NDJSON lines, until they are uploaded as a single gzipped object
+type COSUploader struct {
+	config     COSConfig
+	lines      []string
+	cycles     int
+	lastUpload time.Time
+}
+
+// Helper function that creates an uploader for the given bucket configuration
+func newCOSUploader(config COSConfig) *COSUploader {
+	return &COSUploader{config: config, lastUpload: time.Now()}
+}
+
+// Write buffers the instances of a cycle and uploads the buffer, once the batch size or the flush interval is reached.
+// If the upload fails, the lines are kept for the next attempt, while the oldest lines are dropped once the buffer is full
+func (u *COSUploader) Write(instanceStats []InstanceResourceStats) {
+	for _, stats := range instanceStats {
+		u.lines = append(u.lines, ToJSONString(stats))
+	}
+	u.cycles++
+
+	if overflow := len(u.lines) - u.config.maxBufferLines; overflow > 0 {
+		u.lines = u.lines[overflow:]
+		logger.Warn("Dropping the oldest buffered lines, as the object storage upload is lagging behind", "dropped", overflow)
+	}
+
+	if u.cycles >= u.config.batchCycles || time.Since(u.lastUpload) >= u.config.flushInterval {
+		u.Flush()
+	}
+}
+
+// Flush uploads all buffered lines as a single gzipped NDJSON object, keyed by the current time
+func (u *COSUploader) Flush() {
+	if len(u.lines) == 0 {
+		return
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(strings.Join(u.lines, "\n") + "\n"))
+	if err := gz.Close(); err != nil {
+		logger.Error("Failed to compress the batch for the object storage", "error", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	key := u.config.prefix + now.Format("2006/01/02/150405.000000000") + ".ndjson.gz"
+	err := withRetries(context.Background(), "Upload to object storage", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+		defer cancel()
+		return u.putObject(ctx, key, body.Bytes())
+	})
+	if err != nil {
+		logger.Error("Failed to upload the batch to the object storage, keeping it for the next attempt", "bucket", u.config.bucket, "key", key, "lines", len(u.lines), "error", err)
+		return
+	}
+
+	logger.Debug("Uploaded batch to the object storage", "bucket", u.config.bucket, "key", key, "lines", len(u.lines), "cycles", u.cycles)
+	u.lines = nil
+	u.cycles = 0
+	u.lastUpload = time.Now()
+}
+
+// Helper function that uploads an object via the S3-compatible API, signed with AWS Signature Version 4 (HMAC credentials)
+func (u *COSUploader) putObject(ctx context.Context, key string, payload []byte) error {
+	objectURL, err := url.Parse(strings.TrimSuffix(u.config.endpoint, "/") + "/" + u.config.bucket + "/" + key)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	signS3Request(req, payload, u.config.region, u.config.accessKeyID, u.config.secretAccessKey, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("object storage responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Helper function that signs the given request with AWS Signature Version 4, as supported by IBM Cloud Object Storage
+func signS3Request(req *http.Request, payload []byte, region string, accessKeyID string, secretAccessKey string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-encoding;content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "content-encoding:" + req.Header.Get("Content-Encoding") + "\n" +
+		"content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := req.Method + "\n" + req.URL.EscapedPath() + "\n" + req.URL.RawQuery + "\n" + canonicalHeaders + "\n" + signedHeaders + "\n" + payloadHash
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+type CrashLoop struct {
+	Metric         string `json:"metric"`
+	Namespace      string `json:"namespace"`
+	Name           string `json:"name"`
+	Container      string `json:"container"`
+	Parent         string `json:"parent"`
+	ComponentType  string `json:"component_type"`
+	ComponentName  string `json:"component_name"`
+	RestartCount   int32  `json:"restart_count"`
+	BackoffMessage string `json:"backoff_message"`
+	Message        string `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that determines the containers, which are currently in CrashLoopBackOff.
+// Such pods often do not report any metrics, hence they are detected based on the pod status
+func getCrashLoops(pods []v1.Pod) []CrashLoop {
+	crashLoops := []CrashLoop{}
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			waiting := status.State.Waiting
+			if waiting == nil || waiting.Reason != "CrashLoopBackOff" {
+				continue
+			}
+
+			componentType, componentName, parent := classifyPod(pod.Labels)
+			crashLoop := CrashLoop{
+				Metric:         "crashloop",
+				RecordIdentity: recordIdentity,
+				Namespace:      pod.Namespace,
+				Name:           pod.Name,
+				Container:      status.Name,
+				Parent:         parent,
+				ComponentType:  componentType.String(),
+				ComponentName:  componentName,
+				RestartCount:   status.RestartCount,
+				BackoffMessage: waiting.Message,
+			}
+			crashLoop.Message = "Container '" + crashLoop.Container + "' of " + crashLoop.ComponentType + " instance '" + crashLoop.Name + "' is in CrashLoopBackOff after " + strconv.Itoa(int(crashLoop.RestartCount)) + " restarts"
+			crashLoops = append(crashLoops, crashLoop)
+		}
+	}
+	return crashLoops
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Whether a Kubernetes event is created on the pod, once one of its resource alerts is raised
+var emitEvents = false
+
+// Alerts that have been raised in the previous cycle and whose event has been created, keyed by namespace, pod and resource.
+// An event is only created once an alert is raised, rather than on every cycle it persists
+var activeAlerts = map[string]bool{}
+
+// Helper function that creates a Kubernetes event for each alert, which has not been active in the previous cycle.
+// If creating an event fails, it is created again on the next cycle the alert persists.
+// If creating events is forbidden, a warning is logged and the events are disabled
+func emitAlertEvents(ctx context.Context, coreClientset kubernetes.Interface, alerts []ResourceAlert, podsByName map[string]*v1.Pod) {
+	currentAlerts := make(map[string]bool, len(alerts))
+	for _, alert := range alerts {
+		key := alert.Namespace + "/" + alert.Name + "/" + alert.Resource
+		if activeAlerts[key] {
+			currentAlerts[key] = true
+			continue
+		}
+
+		pod := podsByName[alert.Namespace+"/"+alert.Name]
+		if pod == nil {
+			continue
+		}
+
+		now := metav1.NewTime(time.Now())
+		event := &v1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: pod.Name + ".",
+				Namespace:    pod.Namespace,
+			},
+			InvolvedObject: v1.ObjectReference{
+				Kind:            "Pod",
+				APIVersion:      "v1",
+				Namespace:       pod.Namespace,
+				Name:            pod.Name,
+				UID:             pod.UID,
+				ResourceVersion: pod.ResourceVersion,
+			},
+			Reason:         "HighResourceUsage",
+			Message:        alert.Message,
+			Type:           v1.EventTypeWarning,
+			Source:         v1.EventSource{Component: "metrics-collector"},
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Count:          1,
+		}
+		if _, err := coreClientset.CoreV1().Events(pod.Namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+			if apierrors.IsForbidden(err) {
+				logger.Warn("Disabling events, as creating them is forbidden. Emitting events requires a Role that grants 'create' on 'events'", "error", err)
+				emitEvents = false
+				return
+			}
+			logger.Warn("Failed to create event", "namespace", pod.Namespace, "name", pod.Name, "error", err)
+			continue
+		}
+		currentAlerts[key] = true
+	}
+	activeAlerts = currentAlerts
+}
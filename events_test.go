@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEmitAlertEventsRetriesFailedEvent(t *testing.T) {
+	activeAlerts = map[string]bool{}
+	emitEvents = true
+	defer func() {
+		activeAlerts = map[string]bool{}
+		emitEvents = false
+	}()
+
+	pod := newTestPod("ns", "pod-1")
+	podsByName := map[string]*v1.Pod{"ns/pod-1": &pod}
+	alerts := []ResourceAlert{{Metric: "alert", Resource: "memory", Namespace: "ns", Name: "pod-1", Message: "memory usage is high"}}
+
+	core := fake.NewSimpleClientset()
+	core.PrependReactor("create", "events", newSequenceReactor(apierrors.NewInternalError(errors.New("etcd unavailable")), &v1.Event{}))
+
+	// the failed event is not marked as emitted, hence it is created again on the next cycle
+	emitAlertEvents(context.Background(), core, alerts, podsByName)
+	emitAlertEvents(context.Background(), core, alerts, podsByName)
+	emitAlertEvents(context.Background(), core, alerts, podsByName)
+	if calls := len(core.Actions()); calls != 2 {
+		t.Errorf("got %d create calls, want 2", calls)
+	}
+	if !emitEvents {
+		t.Errorf("got events disabled, want them to stay enabled")
+	}
+}
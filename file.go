@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// File that lines are appended to. Once the file exceeds its maximum size,
+// it is moved to '<path>.1' and a new file is started
+type RotatingFile struct {
+	mutex    sync.Mutex
+	path     string
+	maxBytes int64
+	size     int64
+	file     *os.File
+	writer   *bufio.Writer
+}
+
+// Helper function that opens (or creates) the file at the given path for appending
+func openRotatingFile(path string, maxBytes int64) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxBytes: maxBytes}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+	f.size = info.Size()
+	return nil
+}
+
+// WriteLine appends the given line to the file, rotating the file beforehand if it would exceed its maximum size
+func (f *RotatingFile) WriteLine(line string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(line))+1 > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.writer.WriteString(line + "\n")
+	f.size += int64(n)
+	return err
+}
+
+// Flush writes all buffered lines to disk
+func (f *RotatingFile) Flush() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
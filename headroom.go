@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Factor, by which the peak usage is multiplied to recommend a limit
+var safetyFactor = 1.2
+
+// Peak CPU and memory usage of an instance or component, along with the limits configured at that time
+type usagePeak struct {
+	namespace     string
+	componentType string
+	componentName string
+	cpu           int64
+	memory        int64
+	cpuLimit      int64
+	memoryLimit   int64
+}
+
+// Helper function that raises the peaks to the usage of the given instance
+func (p *usagePeak) add(stats InstanceResourceStats) {
+	p.cpu = max(p.cpu, stats.Cpu.Current)
+	p.memory = max(p.memory, stats.Memory.Current)
+	p.cpuLimit = max(p.cpuLimit, stats.Cpu.Configured)
+	p.memoryLimit = max(p.memoryLimit, stats.Memory.Configured)
+}
+
+// Peaks of all instances captured in the previous cycle, keyed by namespace and instance name
+var instancePeaks = map[string]*usagePeak{}
+
+// Peaks of all components observed over the lifetime of the collector, keyed by namespace, component type and name
+var componentPeaks = map[string]*usagePeak{}
+
+// Helper function that sets the headroom between the configured limits and the peak usage observed so far.
+// Peaks of instances that vanished are dropped, while the peaks per component are kept for the final recommendation
+func applyUsageHeadroom(instanceStats []InstanceResourceStats) {
+	currentPeaks := make(map[string]*usagePeak, len(instanceStats))
+	for i := range instanceStats {
+		stats := &instanceStats[i]
+		key := stats.Namespace + "/" + stats.Name
+		peak, ok := instancePeaks[key]
+		if !ok {
+			peak = &usagePeak{}
+		}
+		peak.add(*stats)
+		currentPeaks[key] = peak
+
+		if stats.Cpu.Configured > 0 {
+			headroom := (stats.Cpu.Configured - peak.cpu) * 100 / stats.Cpu.Configured
+			stats.CpuHeadroomPercent = &headroom
+		}
+		if stats.Memory.Configured > 0 {
+			headroom := (stats.Memory.Configured - peak.memory) * 100 / stats.Memory.Configured
+			stats.MemoryHeadroomPercent = &headroom
+		}
+
+		componentKey := stats.Namespace + "/" + stats.ComponentType + "/" + stats.ComponentName
+		componentPeak, ok := componentPeaks[componentKey]
+		if !ok {
+			componentPeak = &usagePeak{namespace: stats.Namespace, componentType: stats.ComponentType, componentName: stats.ComponentName}
+			componentPeaks[componentKey] = componentPeak
+		}
+		componentPeak.add(*stats)
+	}
+	instancePeaks = currentPeaks
+}
+
+type RightsizingRecommendation struct {
+	Metric                 string `json:"metric"`
+	Namespace              string `json:"namespace"`
+	ComponentType          string `json:"component_type"`
+	ComponentName          string `json:"component_name"`
+	CpuPeak                int64  `json:"cpu_peak"`
+	CpuLimit               int64  `json:"cpu_limit"`
+	CpuRecommendedLimit    int64  `json:"cpu_recommended_limit"`
+	MemoryPeak             int64  `json:"memory_peak"`
+	MemoryLimit            int64  `json:"memory_limit"`
+	MemoryRecommendedLimit int64  `json:"memory_recommended_limit"`
+	Message                string `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that recommends limits for each component, based on the highest usage of a single instance
+// that has been observed over the lifetime of the collector
+func getRightsizingRecommendations() []RightsizingRecommendation {
+	recommendations := []RightsizingRecommendation{}
+	for _, peak := range componentPeaks {
+		recommendation := RightsizingRecommendation{
+			Metric:                 "rightsizing-recommendation",
+			RecordIdentity:         recordIdentity,
+			Namespace:              peak.namespace,
+			ComponentType:          peak.componentType,
+			ComponentName:          peak.componentName,
+			CpuPeak:                peak.cpu,
+			CpuLimit:               peak.cpuLimit,
+			CpuRecommendedLimit:    int64(float64(peak.cpu) * safetyFactor),
+			MemoryPeak:             peak.memory,
+			MemoryLimit:            peak.memoryLimit,
+			MemoryRecommendedLimit: int64(float64(peak.memory) * safetyFactor),
+		}
+		recommendation.Message = "Recommended limits of " + recommendation.ComponentType + " '" + recommendation.ComponentName + "': " + fmt.Sprintf("%d", recommendation.CpuRecommendedLimit) + "m vCPU (configured " + fmt.Sprintf("%d", recommendation.CpuLimit) + "m), " + fmt.Sprintf("%d", recommendation.MemoryRecommendedLimit) + " " + memoryUnit.name + " memory (configured " + fmt.Sprintf("%d", recommendation.MemoryLimit) + " " + memoryUnit.name + ")"
+		recommendations = append(recommendations, recommendation)
+	}
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].Namespace != recommendations[j].Namespace {
+			return recommendations[i].Namespace < recommendations[j].Namespace
+		}
+		if recommendations[i].ComponentType != recommendations[j].ComponentType {
+			return recommendations[i].ComponentType < recommendations[j].ComponentType
+		}
+		return recommendations[i].ComponentName < recommendations[j].ComponentName
+	})
+	return recommendations
+}
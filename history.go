@@ -0,0 +1,88 @@
+package main
+
+// Usage of an instance, as captured in the previous cycle
+type previousUsage struct {
+	cpu         int64
+	memory      int64
+	memoryBytes int64
+}
+
+// Usage of all instances captured in the previous cycle, keyed by namespace and instance name
+var previousUsages = map[string]previousUsage{}
+
+// Helper function that sets the change of the CPU and memory usage since the previous cycle.
+// The memory delta is always reported in MB, independent of the configured 'MEMORY_UNIT'. Instances that vanished are dropped, such that the stored state does not grow unbounded
+func applyUsageDeltas(instanceStats []InstanceResourceStats) {
+	currentUsages := make(map[string]previousUsage, len(instanceStats))
+	for i := range instanceStats {
+		stats := &instanceStats[i]
+		key := stats.Namespace + "/" + stats.Name
+		if previous, ok := previousUsages[key]; ok {
+			cpuDelta := stats.Cpu.Current - previous.cpu
+			memoryDelta := (stats.MemoryBytes - previous.memoryBytes) / bytesPerMB
+			stats.CpuDeltaMillicores = &cpuDelta
+			stats.MemoryDeltaMb = &memoryDelta
+		}
+		currentUsages[key] = previousUsage{cpu: stats.Cpu.Current, memory: stats.Memory.Current, memoryBytes: stats.MemoryBytes}
+	}
+	previousUsages = currentUsages
+}
+
+// Number of samples over which the moving average of the usage is calculated. A window of 0 disables the average
+var avgWindow = 6
+
+// Ring buffer that holds the most recent samples of an instance
+type usageWindow struct {
+	cpu    []int64
+	memory []int64
+	next   int
+}
+
+// Adds a sample to the window, overwriting the oldest one once the window is full
+func (w *usageWindow) add(cpu int64, memory int64) {
+	if len(w.cpu) < avgWindow {
+		w.cpu = append(w.cpu, cpu)
+		w.memory = append(w.memory, memory)
+		return
+	}
+	w.cpu[w.next] = cpu
+	w.memory[w.next] = memory
+	w.next = (w.next + 1) % avgWindow
+}
+
+// Calculates the average CPU and memory usage of all samples in the window
+func (w *usageWindow) average() (int64, int64) {
+	var cpuSum, memorySum int64
+	for i := range w.cpu {
+		cpuSum += w.cpu[i]
+		memorySum += w.memory[i]
+	}
+	return cpuSum / int64(len(w.cpu)), memorySum / int64(len(w.memory))
+}
+
+// Sample windows of all instances captured in the previous cycle, keyed by namespace and instance name
+var usageWindows = map[string]*usageWindow{}
+
+// Helper function that sets the moving average of the CPU and memory usage over the most recent samples.
+// Windows of instances that vanished are dropped
+func applyUsageAverages(instanceStats []InstanceResourceStats) {
+	if avgWindow <= 0 {
+		return
+	}
+
+	currentWindows := make(map[string]*usageWindow, len(instanceStats))
+	for i := range instanceStats {
+		stats := &instanceStats[i]
+		key := stats.Namespace + "/" + stats.Name
+		window, ok := usageWindows[key]
+		if !ok {
+			window = &usageWindow{}
+		}
+		window.add(stats.Cpu.Current, stats.Memory.Current)
+		cpuAvg, memoryAvg := window.average()
+		stats.CpuAvg = &cpuAvg
+		stats.MemoryAvg = &memoryAvg
+		currentWindows[key] = window
+	}
+	usageWindows = currentWindows
+}
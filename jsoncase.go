@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Casing of the JSON keys of all records, either 'snake' (as defined by the struct tags) or 'camel'
+var jsonCase = "snake"
+
+// Helper function that marshals the given object as JSON, converting its keys to the configured casing
+func marshalRecord(obj interface{}) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil || jsonCase != "camel" {
+		return data, err
+	}
+
+	// Re-marshal the generic representation, to transform the keys of all nested objects
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(camelCaseKeys(value, reflect.ValueOf(obj)))
+}
+
+// Helper function that recursively converts the snake_case keys of all objects within the given value to camelCase.
+// The value is walked alongside the Go value it has been marshalled from, such that only the keys of struct fields are
+// converted, while the keys of maps (e.g. pod labels or bucket names) are user data and kept verbatim
+func camelCaseKeys(value interface{}, source reflect.Value) interface{} {
+	for source.IsValid() && (source.Kind() == reflect.Pointer || source.Kind() == reflect.Interface) {
+		source = source.Elem()
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		if !source.IsValid() {
+			for key, item := range v {
+				converted[key] = item
+			}
+			return converted
+		}
+
+		// Types with a custom encoding (e.g. the resource stats) are converted on the top level only
+		if source.Type().Implements(jsonMarshalerType) {
+			for key, item := range v {
+				converted[toCamelCase(key)] = item
+			}
+			return converted
+		}
+
+		switch source.Kind() {
+		case reflect.Struct:
+			fields := map[string]reflect.Value{}
+			collectJSONFields(source, fields)
+			for key, item := range v {
+				converted[toCamelCase(key)] = camelCaseKeys(item, fields[key])
+			}
+		case reflect.Map:
+			for key, item := range v {
+				var mapItem reflect.Value
+				if source.Type().Key().Kind() == reflect.String {
+					mapItem = source.MapIndex(reflect.ValueOf(key).Convert(source.Type().Key()))
+				}
+				converted[key] = camelCaseKeys(item, mapItem)
+			}
+		default:
+			for key, item := range v {
+				converted[key] = item
+			}
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			var element reflect.Value
+			if source.IsValid() && (source.Kind() == reflect.Slice || source.Kind() == reflect.Array) && i < source.Len() {
+				element = source.Index(i)
+			}
+			v[i] = camelCaseKeys(item, element)
+		}
+		return v
+	}
+	return value
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// Helper function that indexes the fields of the given struct by their JSON key, including the fields of embedded structs
+func collectJSONFields(source reflect.Value, fields map[string]reflect.Value) {
+	for i := 0; i < source.NumField(); i++ {
+		field := source.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		value := source.Field(i)
+		if field.Anonymous && name == "" {
+			for value.Kind() == reflect.Pointer && !value.IsNil() {
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct {
+				collectJSONFields(value, fields)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = value
+	}
+}
+
+// Helper function that converts a snake_case key to camelCase, e.g. 'component_type' to 'componentType'
+func toCamelCase(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalRecordInCamelCaseKeepsMapKeys(t *testing.T) {
+	jsonCase = "camel"
+	defer func() { jsonCase = "snake" }()
+
+	stats := InstanceResourceStats{
+		Metric:        "instance-resources",
+		ComponentType: "app",
+		Labels:        map[string]string{"cost_center": "team-a", "app.kubernetes.io/part_of": "shop"},
+		Memory:        ResourceStats{RequestUsage: 42},
+	}
+	data, err := marshalRecord(stats)
+	if err != nil {
+		t.Fatalf("marshalRecord() failed: %v", err)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("failed to decode %s: %v", data, err)
+	}
+	if record["componentType"] != "app" {
+		t.Errorf("got %s, want the key 'componentType'", data)
+	}
+	labels, _ := record["labels"].(map[string]interface{})
+	if labels["cost_center"] != "team-a" || labels["app.kubernetes.io/part_of"] != "shop" {
+		t.Errorf("got labels %v, want the label keys to be kept", labels)
+	}
+	memory, _ := record["memory"].(map[string]interface{})
+	if memory["requestUsage"] != float64(42) {
+		t.Errorf("got memory %v, want the key 'requestUsage'", memory)
+	}
+
+	// the bucket names of the age distribution are data as well
+	data, err = marshalRecord(getAgeDistribution([]InstanceResourceStats{{ComponentType: "app", CreationTimestamp: "2026-10-14T00:00:00Z", AgeSeconds: 10}}))
+	if err != nil {
+		t.Fatalf("marshalRecord() failed: %v", err)
+	}
+	var distribution struct {
+		Buckets        map[string]int            `json:"buckets"`
+		ComponentTypes map[string]map[string]int `json:"componentTypes"`
+	}
+	if err := json.Unmarshal(data, &distribution); err != nil {
+		t.Fatalf("failed to decode %s: %v", data, err)
+	}
+	if distribution.Buckets["lt_1m"] != 1 || distribution.ComponentTypes["app"]["lt_1m"] != 1 {
+		t.Errorf("got %s, want the bucket names to be kept", data)
+	}
+}
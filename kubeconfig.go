@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Clientsets to access the Kube API, which are created once at startup and reused across all cycles.
+// As they are interfaces, fake clientsets can be injected as well, in which case the config may be nil
+type KubeClients struct {
+	config  *rest.Config
+	core    kubernetes.Interface
+	metrics metricsv.Interface
+}
+
+// Helper function that creates the core and metrics clientsets for the given config, retrying failed attempts
+func newKubeClients(config *rest.Config) (*KubeClients, error) {
+	clients := &KubeClients{config: config}
+	err := withRetries(context.Background(), "Create clientsets", func() error {
+		core, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("failed to create core clientset: %w", err)
+		}
+		metrics, err := metricsv.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("failed to create metrics clientset: %w", err)
+		}
+		clients.core = core
+		clients.metrics = metrics
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// Helper function to obtain the config to access the Kube API. To run the collector outside of the cluster,
+// either 'KUBE_HOST' and 'KUBE_TOKEN' or 'KUBECONFIG' can be set. Otherwise, the in-cluster config is used
+func getKubeConfig() (*rest.Config, error) {
+	if host := os.Getenv("KUBE_HOST"); host != "" {
+		return &rest.Config{
+			Host:        host,
+			BearerToken: os.Getenv("KUBE_TOKEN"),
+			TLSClientConfig: rest.TLSClientConfig{
+				CAFile:   os.Getenv("KUBE_CA_FILE"),
+				Insecure: getEnvBool("KUBE_INSECURE_SKIP_TLS_VERIFY", false),
+			},
+		}, nil
+	}
+
+	if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+
+	return rest.InClusterConfig()
+}
+
+// Helper function to obtain the namespace of the current kubeconfig context. If no kubeconfig is available,
+// or its current context does not specify a namespace, an empty string is returned
+func getKubeconfigNamespace() string {
+	rawConfig, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+	if err != nil {
+		return ""
+	}
+	if context, ok := rawConfig.Contexts[rawConfig.CurrentContext]; ok {
+		return context.Namespace
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Level of the operational log messages. Data records are written independent of the level
+var logLevel = new(slog.LevelVar)
+
+// Logger for operational messages. Data records are written separately, such that their JSON shape stays unchanged
+var logger = slog.New(slog.NewJSONHandler(stdoutLogWriter{}, &slog.HandlerOptions{Level: logLevel}))
+
+// Writes log messages to stdout, after flushing the records buffered so far, such that both appear in the order they were written
+type stdoutLogWriter struct{}
+
+func (stdoutLogWriter) Write(p []byte) (int, error) {
+	stdoutMutex.Lock()
+	defer stdoutMutex.Unlock()
+	stdoutWriter.Flush()
+	return os.Stdout.Write(p)
+}
+
+// Helper function to parse a log level, which is one of 'debug', 'info', 'warn' or 'error'
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.ToUpper(value)))
+	return level, err
+}
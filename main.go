@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
@@ -23,26 +32,619 @@ import (
 	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
+// Exit codes of the collector in task mode
+const (
+	// At least one instance has been captured
+	exitCodeSuccess = 0
+	// The collection failed, e.g. because the Kube API could not be reached
+	exitCodeError = 1
+	// The metrics API was reachable, but did not report any instance
+	exitCodeNoInstances = 2
+)
+
+// Whether the resource usage of sidecar containers (e.g. the Knative queue-proxy) should be taken into account
+var includeSidecars = true
+
+// Name of the container of an app, that runs the user workload
+var appContainerName = "user-container"
+
+// Names of the sidecar containers injected into app pods, which never run the user workload
+var sidecarContainerNames = map[string]bool{
+	"queue-proxy": true,
+	"istio-proxy": true,
+}
+
+// How often, and with which initial delay, failed Kube API calls are retried
+var maxRetries = 3
+var retryBaseMs = 500
+
+// Maximum duration of the Kube API calls of a single collection cycle
+var apiTimeout = 30 * time.Second
+
+// Format in which the instance stats are written, either 'lines' or 'array'
+var outputMode = "lines"
+
+// Pods in these phases are not reported
+var excludedPhases = map[string]bool{"Succeeded": true, "Failed": true}
+
+// Component types that are reported. If nil, all component types are reported
+var collectedComponentTypes map[ComponentType]bool
+
+// Whether pods that could not be classified (e.g. system pods or the collector itself) are skipped
+var skipUnknown = false
+
+// Number of items fetched per page of the list calls
+var pageLimit int64 = 100
+
+// Upper bound of the page limit, to not overwhelm the Kube API
+const maxPageLimit = 1000
+
+// Number of namespaces, whose pods and pod metrics are fetched concurrently
+var maxConcurrency = 4
+
+// Unit in which memory values are reported
+type MemoryUnit struct {
+	name    string
+	divisor float64
+}
+
+var memoryUnits = map[string]MemoryUnit{
+	"mb":  {name: "MB", divisor: 1000 * 1000},
+	"mib": {name: "MiB", divisor: 1024 * 1024},
+}
+var memoryUnit = memoryUnits["mb"]
+
+// Optional label selector that restricts the pods that are observed
+var labelSelector string
+
+// Optional name of a single pod, to which the collection is restricted
+var targetPod string
+
+// Whether a record is written for pods, for which the metrics API did not report any metric yet
+var emitUnmeasured = false
+
+// Number of remaining cycles, whose records are suppressed after the start of the daemon
+var warmupCycles = 0
+
+// Optional label selector that selects the namespaces to observe, e.g. the ones of all Code Engine projects
+var namespaceSelector string
+
+// Optional field selector that restricts the pods that are observed, e.g. 'status.phase=Running'
+var fieldSelector string
+
 func main() {
 
+	// The 'CONFIG_FILE' env var points to a YAML or JSON file, which sets env vars that are not set explicitly
+	var configKeys []string
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		keys, err := loadConfigFile(path)
+		if err != nil {
+			logger.Error("Invalid CONFIG_FILE", "error", err)
+			os.Exit(exitCodeError)
+		}
+		configKeys = keys
+	}
+
 	jobMode := os.Getenv("JOB_MODE")
 
-	// In task mode, collect the resource metrics once
-	if jobMode == "task" {
-		collectInstanceMetrics()
-		return
+	// The 'LOG_LEVEL' env var controls which operational messages are written, e.g. 'debug' to include the per-cycle messages
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		level, err := parseLogLevel(value)
+		if err != nil {
+			logger.Error("Invalid LOG_LEVEL", "value", value)
+			os.Exit(exitCodeError)
+		}
+		logLevel.Set(level)
+	}
+	if len(configKeys) > 0 {
+		logEffectiveConfig(configKeys)
+	}
+
+	// If the 'INCLUDE_SIDECARS' env var is set to false, only the user container of each instance is observed
+	includeSidecars = getEnvBool("INCLUDE_SIDECARS", true)
+
+	// If the 'APP_CONTAINER_NAME' env var is set, it overrides the name of the user container of apps
+	if name := os.Getenv("APP_CONTAINER_NAME"); name != "" {
+		appContainerName = name
+	}
+
+	// The 'MAX_RETRIES' and 'RETRY_BASE_MS' env vars control the exponential backoff of failed API calls
+	maxRetries = getEnvInt("MAX_RETRIES", maxRetries)
+	retryBaseMs = getEnvInt("RETRY_BASE_MS", retryBaseMs)
+
+	// The 'API_TIMEOUT_SECONDS' env var limits how long a collection cycle may wait for the Kube API
+	if t := getEnvInt("API_TIMEOUT_SECONDS", 30); t > 0 {
+		apiTimeout = time.Duration(t) * time.Second
+	}
+
+	// The 'ROUND_MODE' env var controls whether usage percentages are truncated ('truncate'), rounded ('round') or rounded up ('ceil')
+	if value := os.Getenv("ROUND_MODE"); value != "" {
+		if value != "truncate" && value != "round" && value != "ceil" {
+			logger.Error("Invalid ROUND_MODE, expected 'truncate', 'round' or 'ceil'", "value", value)
+			os.Exit(exitCodeError)
+		}
+		roundMode = value
+	}
+
+	// If the 'RAW_QUANTITIES' env var is set to true, the original quantities are written alongside the numeric values
+	rawQuantities = getEnvBool("RAW_QUANTITIES", false)
+
+	// If the 'PRECISION' env var is set to 'float', current and configured values are not truncated to integers
+	floatPrecision = os.Getenv("PRECISION") == "float"
+
+	// The 'CPU_ALERT_PERCENT' and 'MEMORY_ALERT_PERCENT' env vars enable alerts for instances that are close to their limits
+	cpuAlertPercent = int64(getEnvInt("CPU_ALERT_PERCENT", 0))
+	memoryAlertPercent = int64(getEnvInt("MEMORY_ALERT_PERCENT", 0))
+
+	// The 'EMIT_MODE' env var set to 'changed' only writes the record of an instance, once its usage changed by the configured deltas
+	if value := os.Getenv("EMIT_MODE"); value != "" {
+		if value != "all" && value != "changed" {
+			logger.Error("Invalid EMIT_MODE, expected 'all' or 'changed'", "value", value)
+			os.Exit(exitCodeError)
+		}
+		emitMode = value
+	}
+	changedCpuDelta = int64(getEnvInt("CHANGED_CPU_DELTA", int(changedCpuDelta)))
+	changedMemoryDelta = int64(getEnvInt("CHANGED_MEMORY_DELTA", int(changedMemoryDelta)))
+
+	// The 'SORT_BY' env var defines the order, in which the instances of a cycle are written
+	if value := os.Getenv("SORT_BY"); value != "" {
+		if value != "name" && value != "cpu" && value != "memory" && value != "component_type" {
+			logger.Error("Invalid SORT_BY, expected 'name', 'cpu', 'memory' or 'component_type'", "value", value)
+			os.Exit(exitCodeError)
+		}
+		sortBy = value
+	}
+
+	// The 'MIN_CPU_PERCENT' and 'MIN_MEMORY_PERCENT' env vars suppress the records of instances, whose usage is below both floors
+	minCpuPercent = int64(getEnvInt("MIN_CPU_PERCENT", 0))
+	minMemoryPercent = int64(getEnvInt("MIN_MEMORY_PERCENT", 0))
+
+	// The 'AVG_WINDOW' env var controls over how many cycles the moving average of the usage is calculated
+	avgWindow = getEnvInt("AVG_WINDOW", avgWindow)
+
+	// The 'SAFETY_FACTOR' env var controls by how much the recommended limits exceed the peak usage
+	if value := os.Getenv("SAFETY_FACTOR"); value != "" {
+		factor, err := strconv.ParseFloat(value, 64)
+		if err != nil || factor <= 0 {
+			logger.Error("Invalid SAFETY_FACTOR, expected a positive number", "value", value)
+			os.Exit(exitCodeError)
+		}
+		safetyFactor = factor
+	}
+
+	// The 'PERCENTILE_WINDOW' and 'PERCENTILE_EVERY' env vars control over how many samples, and how often, the usage percentiles are calculated
+	percentileWindow = getEnvInt("PERCENTILE_WINDOW", percentileWindow)
+	percentileEvery = getEnvInt("PERCENTILE_EVERY", percentileEvery)
+
+	// The 'MEMORY_UNIT' env var switches memory values from MB ('mb') to MiB ('mib'), which matches the Kubernetes quantities
+	if value := os.Getenv("MEMORY_UNIT"); value != "" {
+		unit, ok := memoryUnits[strings.ToLower(value)]
+		if !ok {
+			logger.Error("Invalid MEMORY_UNIT, expected 'mb' or 'mib'", "value", value)
+			os.Exit(exitCodeError)
+		}
+		memoryUnit = unit
+	}
+
+	// If the 'CONTAINER_DETAIL' env var is set to true, the usage of each container is reported separately
+	containerDetail = getEnvBool("CONTAINER_DETAIL", false)
+
+	// The 'OUTPUT_MODE' env var controls whether each instance is written as a separate line or all together
+	if m := os.Getenv("OUTPUT_MODE"); m != "" {
+		if !isValidOutputMode(m) {
+			logger.Error("Invalid OUTPUT_MODE", "value", m)
+			os.Exit(exitCodeError)
+		}
+		outputMode = m
+	}
+
+	// The 'SINKS' env var restricts the outputs to the given combination, e.g. 'stdout,prometheus,file'
+	if value := os.Getenv("SINKS"); value != "" {
+		sinks, err := parseSinks(value)
+		if err != nil {
+			logger.Error("Invalid SINKS", "value", value, "error", err)
+			os.Exit(exitCodeError)
+		}
+		enabledSinks = sinks
+	}
+
+	// The 'OUTPUT_FILE' env var additionally appends all records to the given file, which is rotated once it exceeds 'OUTPUT_FILE_MAX_MB'
+	if path := os.Getenv("OUTPUT_FILE"); path != "" && isSinkEnabled("file") {
+		var err error
+		outputFile, err = openRotatingFile(path, int64(getEnvInt("OUTPUT_FILE_MAX_MB", 10))*1000*1000)
+		if err != nil {
+			logger.Error("Failed to open OUTPUT_FILE", "path", path, "error", err)
+			os.Exit(exitCodeError)
+		}
+	}
+
+	// The 'CLASSIFICATION_RULES' env var allows to classify custom workloads, in addition to the built-in rules
+	if r := os.Getenv("CLASSIFICATION_RULES"); r != "" {
+		rules, err := parseClassificationRules(r)
+		if err != nil {
+			logger.Error("Invalid CLASSIFICATION_RULES", "error", err)
+			os.Exit(exitCodeError)
+		}
+		customClassificationRules = rules
+	}
+
+	// The 'COMPONENT_TYPES' env var restricts the component types that are reported, e.g. 'app,job'
+	if value := os.Getenv("COMPONENT_TYPES"); value != "" {
+		collectedComponentTypes = map[ComponentType]bool{}
+		for _, name := range splitList(value) {
+			componentType := parseComponentType(name)
+			if componentType == Unknown && name != Unknown.String() {
+				logger.Error("Invalid COMPONENT_TYPES entry", "value", name)
+				os.Exit(exitCodeError)
+			}
+			collectedComponentTypes[componentType] = true
+		}
+	}
+
+	// If the 'SKIP_UNKNOWN' env var is set to true, pods that could not be classified are not reported
+	skipUnknown = getEnvBool("SKIP_UNKNOWN", false)
+
+	// The 'EXCLUDE_PHASES' env var overrides the pod phases that are skipped. Set it to an empty value to report pods in all phases
+	if value, ok := os.LookupEnv("EXCLUDE_PHASES"); ok {
+		excludedPhases = map[string]bool{}
+		for _, phase := range splitList(value) {
+			excludedPhases[phase] = true
+		}
+	}
+
+	// The 'PAGE_LIMIT' env var controls the page size of the list calls
+	if value := os.Getenv("PAGE_LIMIT"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			logger.Error("Invalid PAGE_LIMIT, expected a positive integer", "value", value)
+			os.Exit(exitCodeError)
+		}
+		if limit > maxPageLimit {
+			logger.Warn("PAGE_LIMIT exceeds the maximum, using the maximum instead", "value", limit, "maximum", maxPageLimit)
+			limit = maxPageLimit
+		}
+		pageLimit = limit
+	}
+
+	// The 'MAX_CONCURRENCY' env var controls how many namespaces are fetched concurrently
+	if c := getEnvInt("MAX_CONCURRENCY", maxConcurrency); c > 0 {
+		maxConcurrency = c
+	}
+
+	// The 'LIMIT_ANNOTATION_CPU' and 'LIMIT_ANNOTATION_MEMORY' env vars name pod annotations, which override the limits the usage is calculated against
+	limitAnnotationCpu = os.Getenv("LIMIT_ANNOTATION_CPU")
+	limitAnnotationMemory = os.Getenv("LIMIT_ANNOTATION_MEMORY")
+
+	// The 'PROPAGATE_LABELS' env var lists the keys of pod labels, that are copied into each instance record
+	propagatedLabels = splitList(os.Getenv("PROPAGATE_LABELS"))
+
+	// The 'LABEL_SELECTOR' env var restricts the collection to the matching pods, e.g. 'serving.knative.dev/service=my-app'
+	labelSelector = os.Getenv("LABEL_SELECTOR")
+	if _, err := labels.Parse(labelSelector); err != nil {
+		logger.Error("Invalid LABEL_SELECTOR", "value", labelSelector, "error", err)
+		os.Exit(exitCodeError)
+	}
+
+	// The 'NAMESPACE_SELECTOR' env var observes all namespaces with matching labels, which are listed on each cycle.
+	// This picks up new projects without a config change, but requires the permission to list namespaces
+	namespaceSelector = os.Getenv("NAMESPACE_SELECTOR")
+	if _, err := labels.Parse(namespaceSelector); err != nil {
+		logger.Error("Invalid NAMESPACE_SELECTOR", "value", namespaceSelector, "error", err)
+		os.Exit(exitCodeError)
+	}
+
+	// If the 'MESSAGE_TEMPLATE' env var is set, it replaces the default message of each instance record
+	if text := os.Getenv("MESSAGE_TEMPLATE"); text != "" {
+		tmpl, err := parseMessageTemplate(text)
+		if err != nil {
+			logger.Warn("Ignoring invalid MESSAGE_TEMPLATE, falling back to the default message", "value", text, "error", err)
+		} else {
+			messageTemplate = tmpl
+		}
+	}
+
+	// If the 'COLLECT_NODES' env var is set to true, the usage and allocatable resources of each node are reported as well
+	collectNodes = getEnvBool("COLLECT_NODES", false)
+
+	// If the 'ROLLUP_INTERVAL' env var is set, the rolled-up views are written at most once per that many seconds
+	rollupInterval = time.Duration(getEnvInt("ROLLUP_INTERVAL", 0)) * time.Second
+
+	// The 'TOP_K' env var controls how many top CPU and memory consumers are reported per cycle
+	topK = getEnvInt("TOP_K", topK)
+
+	// If the 'EMIT_EVENTS' env var is set to true, a Kubernetes event is created on the pod, once one of its resource alerts is raised
+	emitEvents = getEnvBool("EMIT_EVENTS", false)
+
+	// If the 'EMIT_UNMEASURED' env var is set to true, pods without metrics are reported with zero usage
+	emitUnmeasured = getEnvBool("EMIT_UNMEASURED", false)
+
+	// The 'CIRCUIT_FAILURE_THRESHOLD' env var defines after how many consecutive failed cycles the interval is backed off,
+	// up to 'CIRCUIT_MAX_INTERVAL_SECONDS'
+	circuitFailureThreshold = getEnvInt("CIRCUIT_FAILURE_THRESHOLD", circuitFailureThreshold)
+	circuitMaxInterval = time.Duration(getEnvInt("CIRCUIT_MAX_INTERVAL_SECONDS", int(circuitMaxInterval.Seconds()))) * time.Second
+
+	// If the 'WARMUP_CYCLES' env var is set, the first cycles of the daemon are collected, but not emitted
+	warmupCycles = getEnvInt("WARMUP_CYCLES", 0)
+
+	// The 'TRACE_POD' env var writes the raw metrics and the relevant spec fields of the pod with the given name on each cycle
+	tracePod = os.Getenv("TRACE_POD")
+
+	// The 'JSON_CASE' env var switches the keys of all records from snake_case ('snake') to camelCase ('camel')
+	if value := os.Getenv("JSON_CASE"); value != "" {
+		if value != "snake" && value != "camel" {
+			logger.Error("Invalid JSON_CASE, expected 'snake' or 'camel'", "value", value)
+			os.Exit(exitCodeError)
+		}
+		jsonCase = value
+	}
+
+	// If the 'TARGET_POD' env var is set, only the pod with that name is observed, e.g. to investigate a single instance
+	targetPod = os.Getenv("TARGET_POD")
+
+	// The 'FIELD_SELECTOR' env var restricts the collection to the matching pods, e.g. 'status.phase=Running'
+	fieldSelector = os.Getenv("FIELD_SELECTOR")
+	if _, err := fields.ParseSelector(fieldSelector); err != nil {
+		logger.Error("Invalid FIELD_SELECTOR", "value", fieldSelector, "error", err)
+		os.Exit(exitCodeError)
 	}
 
 	// If the 'INTERVAL' env var is set then sleep for that many seconds
 	sleepDuration := 10
 	if t := os.Getenv("INTERVAL"); t != "" {
-		sleepDuration, _ = strconv.Atoi(t)
+		if d, err := strconv.Atoi(t); err == nil && d > 0 {
+			sleepDuration = d
+		}
+	}
+
+	// If the 'INTERVAL_JITTER_PERCENT' env var is set, each interval is randomized by +/- that percentage
+	if p := getEnvInt("INTERVAL_JITTER_PERCENT", 0); p >= 0 && p <= 100 {
+		intervalJitterPercent = p
+	} else {
+		logger.Warn("Ignoring invalid INTERVAL_JITTER_PERCENT, it must be between 0 and 100", "value", p)
+	}
+
+	// The 'COLLECTOR_ID' and 'PROJECT_ID' env vars identify the collector in every record it writes
+	recordIdentity = RecordIdentity{
+		CollectorID: os.Getenv("COLLECTOR_ID"),
+		ProjectID:   os.Getenv("PROJECT_ID"),
+	}
+
+	// If the 'STDOUT_ENABLED' env var is set to false, records are only written to the other configured outputs
+	stdoutEnabled = getEnvBool("STDOUT_ENABLED", true) && isSinkEnabled("stdout")
+
+	// The 'OTLP_ENDPOINT' env var enables exporting the metrics to an OpenTelemetry collector, e.g. 'http://otel-collector:4318'
+	var otlpExporter *OTLPExporter
+	if endpoint := os.Getenv("OTLP_ENDPOINT"); endpoint != "" {
+		var err error
+		otlpExporter, err = newOTLPExporter(context.Background(), endpoint, time.Duration(sleepDuration)*time.Second)
+		if err != nil {
+			logger.Error("Failed to set up the OTLP exporter", "endpoint", endpoint, "error", err)
+			os.Exit(exitCodeError)
+		}
+		defer otlpExporter.Shutdown()
+	}
+
+	// The 'STATSD_ADDR' env var sends the instance gauges to a StatsD agent via UDP, e.g. 'localhost:8125'
+	statsdAddr = os.Getenv("STATSD_ADDR")
+
+	// The 'COS_BUCKET' env var uploads batches of cycles as gzipped NDJSON objects to an IBM Cloud Object Storage bucket
+	var cosUploader *COSUploader
+	if bucket := os.Getenv("COS_BUCKET"); bucket != "" {
+		cosConfig := COSConfig{
+			endpoint:        os.Getenv("COS_ENDPOINT"),
+			bucket:          bucket,
+			region:          os.Getenv("COS_REGION"),
+			accessKeyID:     os.Getenv("COS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("COS_SECRET_ACCESS_KEY"),
+			prefix:          os.Getenv("COS_PREFIX"),
+			batchCycles:     getEnvInt("COS_BATCH_CYCLES", 30),
+			flushInterval:   time.Duration(getEnvInt("COS_FLUSH_SECONDS", 300)) * time.Second,
+			maxBufferLines:  getEnvInt("COS_MAX_BUFFER_LINES", 100000),
+		}
+		if cosConfig.region == "" {
+			cosConfig.region = "us-standard"
+		}
+		if cosConfig.endpoint == "" || cosConfig.accessKeyID == "" || cosConfig.secretAccessKey == "" {
+			logger.Error("COS_BUCKET requires COS_ENDPOINT, COS_ACCESS_KEY_ID and COS_SECRET_ACCESS_KEY to be set")
+			os.Exit(exitCodeError)
+		}
+		cosUploader = newCOSUploader(cosConfig)
+	}
+
+	// The 'SINK_URL' env var posts the instances of each cycle as gzipped JSON document to an HTTP endpoint
+	sinkURL = os.Getenv("SINK_URL")
+	if t := getEnvInt("SINK_TIMEOUT_SECONDS", 10); t > 0 {
+		sinkTimeout = time.Duration(t) * time.Second
+	}
+
+	// Each sink that is enabled explicitly needs to be configured as well
+	for name, configured := range map[string]bool{"file": outputFile != nil, "otlp": otlpExporter != nil, "http": sinkURL != "", "statsd": statsdAddr != "", "cos": cosUploader != nil} {
+		if enabledSinks[name] && !configured {
+			logger.Error("SINKS enables the '" + name + "' sink, but it is not configured")
+			os.Exit(exitCodeError)
+		}
+	}
+
+	// Collect the sinks, to which the instances of each cycle are written in addition to the records
+	sinks := []Sink{}
+	if otlpExporter != nil && isSinkEnabled("otlp") {
+		sinks = append(sinks, otlpExporter)
+	}
+	if sinkURL != "" && isSinkEnabled("http") {
+		sinks = append(sinks, sinkFunc(sendToSink))
+	}
+	if statsdAddr != "" && isSinkEnabled("statsd") {
+		sinks = append(sinks, sinkFunc(sendToStatsD))
+	}
+	if cosUploader != nil && isSinkEnabled("cos") {
+		sinks = append(sinks, cosUploader)
+	}
+
+	config, err := getKubeConfig()
+	if err != nil {
+		logger.Error("Failed to load the Kube API config", "error", err)
+		os.Exit(exitCodeError)
+	}
+	clients, err := newKubeClients(config)
+	if err != nil {
+		logger.Error("Failed to create the Kube API clientsets", "error", err)
+		os.Exit(exitCodeError)
+	}
+
+	// In validate mode, verify that pods can be listed and classified, without relying on the metrics API
+	if jobMode == "validate" {
+		if err := validatePods(clients); err != nil {
+			logCollectorError(err)
+			os.Exit(exitCodeError)
+		}
+		return
+	}
+
+	// In task mode, collect the resource metrics once, hence there is nothing to warm up
+	if jobMode == "task" {
+		warmupCycles = 0
+		instanceStats, err := collectInstanceMetrics(clients)
+		if err != nil {
+			logCollectorError(err)
+			os.Exit(exitCodeError)
+		}
+		writeToSinks(sinks, instanceStats)
+		if cosUploader != nil {
+			cosUploader.Flush()
+		}
+
+		// The 'PUSHGATEWAY_URL' env var pushes the collected metrics to a Prometheus Pushgateway, as there is nothing to scrape in task mode
+		if pushgatewayURL := os.Getenv("PUSHGATEWAY_URL"); pushgatewayURL != "" {
+			job := os.Getenv("PUSHGATEWAY_JOB")
+			if job == "" {
+				job = "metrics-collector"
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+			defer cancel()
+			if err := pushToPushgateway(ctx, pushgatewayURL, job, instanceStats); err != nil {
+				logger.Error("Failed to push metrics to the Pushgateway", "url", pushgatewayURL, "error", err)
+				os.Exit(exitCodeError)
+			}
+		}
+
+		// Signal, whether the metrics API did not report any instance
+		if len(instanceStats) == 0 {
+			if otlpExporter != nil {
+				otlpExporter.Shutdown()
+			}
+			os.Exit(exitCodeNoInstances)
+		}
+		return
 	}
 
-	// In daemon mode, collect resource metrics in an endless loop
+	// In report mode, collect the resource metrics once and write all aggregates as a single capacity report
+	if jobMode == "report" {
+		warmupCycles = 0
+		recordsSuppressed = true
+		instanceStats, err := collectInstanceMetrics(clients)
+		recordsSuppressed = false
+		if err != nil {
+			logCollectorError(err)
+			os.Exit(exitCodeError)
+		}
+		writeRecord(getCapacityReport(time.Now(), instanceStats))
+		flushOutput()
+
+		// Signal, whether the metrics API did not report any instance
+		if len(instanceStats) == 0 {
+			os.Exit(exitCodeNoInstances)
+		}
+		return
+	}
+
+	// In daemon mode, expose the most recent metrics in the Prometheus text format
+	metricsPort := "9090"
+	if p := os.Getenv("METRICS_PORT"); p != "" {
+		metricsPort = p
+	}
+	registry := &PrometheusRegistry{}
+	snapshots := &SnapshotStore{}
+
+	// Readiness is lost, if no collection succeeded within two intervals
+	health := &HealthState{maxSuccessAge: 2 * time.Duration(sleepDuration) * time.Second}
+	startHTTPServer(metricsPort, registry, snapshots, health)
+
+	// In daemon mode, the most recent metrics are served via the HTTP endpoints as well
+	daemonSinks := []Sink{}
+	if isSinkEnabled("prometheus") {
+		daemonSinks = append(daemonSinks, registry)
+	}
+	if isSinkEnabled("snapshot") {
+		daemonSinks = append(daemonSinks, snapshots)
+	}
+	sinks = append(daemonSinks, sinks...)
+
+	// The 'PPROF_PORT' env var exposes the pprof profiles of the daemon, e.g. to capture CPU and heap profiles under heavy load
+	if pprofPort := os.Getenv("PPROF_PORT"); pprofPort != "" {
+		startPprofServer(pprofPort)
+	}
+
+	// Stop the daemon gracefully, once the container gets terminated
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	// In daemon mode, collect resource metrics in an endless loop. Each cycle is scheduled relative to the previous one,
+	// such that slow cycles do not delay the following ones
+	interval := time.Duration(sleepDuration) * time.Second
+	nextCycle := time.Now()
+
+	// A failing cycle is logged, the next one is attempted on the next tick
+	collect := func() bool {
+		cycleStart := time.Now()
+		defer func() {
+			warnOnIntervalOverrun(time.Since(cycleStart), interval)
+		}()
+
+		instanceStats, err := collectInstanceMetrics(clients)
+		if err != nil {
+			logCollectorError(err)
+			return false
+		}
+		writeToSinks(sinks, instanceStats)
+		health.MarkSuccess()
+		return true
+	}
+
+	// Sustained failures back off the interval, until a cycle succeeds again
+	breaker := &CircuitBreaker{}
+
+	health.MarkStarted()
+	cycles := 0
 	for {
-		collectInstanceMetrics()
-		time.Sleep(time.Duration(sleepDuration) * time.Second)
+		breaker.RecordResult(collect(), interval)
+		cycles++
+
+		nextCycle = nextCycle.Add(getJitteredInterval(breaker.Interval(interval)))
+		if now := time.Now(); nextCycle.Before(now) {
+			nextCycle = now
+		}
+
+		select {
+		case <-ctx.Done():
+			// Flush a final collection, before shutting down
+			collect()
+			cycles++
+
+			// Recommend limits based on the peak usage observed over the lifetime of the daemon
+			for _, recommendation := range getRightsizingRecommendations() {
+				writeRecord(recommendation)
+			}
+			flushOutput()
+
+			// Upload the cycles buffered since the last batch
+			if cosUploader != nil {
+				cosUploader.Flush()
+			}
+			logger.Info("Metrics collector is shutting down after " + strconv.Itoa(cycles) + " cycles")
+			return
+		case <-time.After(time.Until(nextCycle)):
+		}
 	}
 }
 
@@ -55,6 +657,19 @@ const (
 	Build
 )
 
+// Helper function to parse the string representation of a component type
+func parseComponentType(s string) ComponentType {
+	switch s {
+	case "app":
+		return App
+	case "job":
+		return Job
+	case "build":
+		return Build
+	}
+	return Unknown
+}
+
 func (s ComponentType) String() string {
 	switch s {
 	case App:
@@ -68,201 +683,753 @@ func (s ComponentType) String() string {
 }
 
 type ResourceStats struct {
-	Current    int64 `json:"current"`
-	Configured int64 `json:"configured"`
-	Usage      int64 `json:"usage"`
+	Current       int64  `json:"current"`
+	Configured    int64  `json:"configured"`
+	Usage         int64  `json:"usage"`
+	Requested     int64  `json:"requested"`
+	RequestUsage  int64  `json:"request_usage"`
+	Enforced      *int64 `json:"enforced,omitempty"`
+	CurrentRaw    string `json:"current_raw,omitempty"`
+	ConfiguredRaw string `json:"configured_raw,omitempty"`
+	RequestedRaw  string `json:"requested_raw,omitempty"`
+
+	// Untruncated values, which are written instead of 'Current' and 'Configured' if the float precision is enabled
+	currentExact    float64
+	configuredExact float64
+}
+
+// Whether current and configured values are written as floats, rather than being truncated to integers
+var floatPrecision = false
+
+// Whether the original quantities (e.g. '500m', '512Mi') are written alongside the numeric values
+var rawQuantities = false
+
+// Helper function that returns the string form of the given quantity, if raw quantities are enabled and the quantity is set
+func getRawQuantity(quantity *resource.Quantity) string {
+	if !rawQuantities || quantity == nil || quantity.IsZero() {
+		return ""
+	}
+	return quantity.String()
+}
+
+// MarshalJSON writes the untruncated current and configured values, if the float precision is enabled
+func (r ResourceStats) MarshalJSON() ([]byte, error) {
+	type plainResourceStats ResourceStats
+	if !floatPrecision {
+		return json.Marshal(plainResourceStats(r))
+	}
+	return json.Marshal(struct {
+		plainResourceStats
+		Current    float64 `json:"current"`
+		Configured float64 `json:"configured"`
+	}{plainResourceStats(r), r.currentExact, r.configuredExact})
 }
 
 type InstanceResourceStats struct {
-	Metric           string        `json:"metric"`
-	Name             string        `json:"name"`
-	Parent           string        `json:"parent"`
-	ComponentType    string        `json:"component_type"`
-	ComponentName    string        `json:"component_name"`
-	Cpu              ResourceStats `json:"cpu"`
-	Memory           ResourceStats `json:"memory"`
-	EphemeralStorage ResourceStats `json:"ephemeral_storage"`
-	Message          string        `json:"message"`
+	Metric                string            `json:"metric"`
+	Namespace             string            `json:"namespace"`
+	Name                  string            `json:"name"`
+	Parent                string            `json:"parent"`
+	ComponentType         string            `json:"component_type"`
+	ComponentName         string            `json:"component_name"`
+	Labels                map[string]string `json:"labels,omitempty"`
+	NodeName              string            `json:"node_name,omitempty"`
+	Phase                 string            `json:"phase,omitempty"`
+	QosClass              string            `json:"qos_class,omitempty"`
+	RestartCount          int32             `json:"restart_count"`
+	LastTerminationReason string            `json:"last_termination_reason,omitempty"`
+	CreationTimestamp     string            `json:"creation_timestamp,omitempty"`
+	AgeSeconds            int64             `json:"age_seconds,omitempty"`
+	Cpu                   ResourceStats     `json:"cpu"`
+	Memory                ResourceStats     `json:"memory"`
+	MemoryBytes           int64             `json:"memory_bytes"`
+	MemoryPressure        string            `json:"memory_pressure,omitempty"`
+	EphemeralStorage      ResourceStats     `json:"ephemeral_storage"`
+	Gpu                   *ResourceStats    `json:"gpu,omitempty"`
+	CpuDeltaMillicores    *int64            `json:"cpu_delta_millicores,omitempty"`
+	MemoryDeltaMb         *int64            `json:"memory_delta_mb,omitempty"`
+	CpuAvg                *int64            `json:"cpu_avg,omitempty"`
+	MemoryAvg             *int64            `json:"memory_avg,omitempty"`
+	CpuHeadroomPercent    *int64            `json:"cpu_headroom_percent,omitempty"`
+	MemoryHeadroomPercent *int64            `json:"memory_headroom_percent,omitempty"`
+	Unmeasured            bool              `json:"unmeasured,omitempty"`
+	Timestamp             string            `json:"timestamp"`
+	CollectionDurationMs  int64             `json:"collection_duration_ms"`
+	SampledAt             string            `json:"sampled_at,omitempty"`
+	WindowSeconds         float64           `json:"window_seconds,omitempty"`
+	Message               string            `json:"message"`
+	RecordIdentity
+}
+
+type CollectionSummary struct {
+	Metric       string `json:"metric"`
+	Pods         int    `json:"pods"`
+	Metrics      int    `json:"metrics"`
+	DurationMs   int64  `json:"duration_ms"`
+	Partial      bool   `json:"partial"`
+	PodPages     int    `json:"pod_pages"`
+	MetricsPages int    `json:"metrics_pages"`
+	Message      string `json:"message"`
+	RecordIdentity
+}
+
+type APILatency struct {
+	Metric        string `json:"metric"`
+	PodsListMs    int64  `json:"pods_list_ms"`
+	MetricsListMs int64  `json:"metrics_list_ms"`
+	ProcessingMs  int64  `json:"processing_ms"`
+	Message       string `json:"message"`
+	RecordIdentity
+}
+
+// Signals that the metrics API (metrics.k8s.io) could not be reached, e.g. because the metrics-server is not ready
+var errMetricsAPIUnavailable = errors.New("metrics API is unavailable")
+
+type CollectorError struct {
+	Metric  string `json:"metric"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that writes a structured log line for a failed collection cycle
+func logCollectorError(err error) {
+	collectorError := CollectorError{
+		Metric:         "collector-error",
+		RecordIdentity: recordIdentity,
+		Reason:         "collection-failed",
+		Message:        "Failed to collect metrics - " + err.Error(),
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		collectorError.Reason = "timeout"
+	} else if errors.Is(err, errMetricsAPIUnavailable) {
+		collectorError.Reason = "metrics-api-unavailable"
+	}
+	writeRecord(collectorError)
+	flushOutput()
+}
+
+// Percentage by which each collection interval is randomized, to avoid many collectors hitting the metrics API in lockstep
+var intervalJitterPercent = 0
+
+// Helper function that randomizes the given interval by +/- the configured jitter percentage
+func getJitteredInterval(interval time.Duration) time.Duration {
+	if intervalJitterPercent == 0 {
+		return interval
+	}
+	jitter := float64(interval) * float64(intervalJitterPercent) / 100
+	return interval + time.Duration((rand.Float64()*2-1)*jitter)
+}
+
+type IntervalOverrun struct {
+	Metric          string `json:"metric"`
+	DurationMs      int64  `json:"duration_ms"`
+	IntervalSeconds int64  `json:"interval_seconds"`
+	Message         string `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that writes a structured warning, if a collection cycle took longer than the configured interval
+func warnOnIntervalOverrun(duration time.Duration, interval time.Duration) {
+	if duration <= interval {
+		return
+	}
+	overrun := IntervalOverrun{
+		Metric:          "interval-overrun",
+		RecordIdentity:  recordIdentity,
+		DurationMs:      duration.Milliseconds(),
+		IntervalSeconds: int64(interval.Seconds()),
+	}
+	overrun.Message = "Collection cycle took " + strconv.FormatInt(overrun.DurationMs, 10) + "ms, which exceeds the interval of " + strconv.FormatInt(overrun.IntervalSeconds, 10) + "s"
+	writeRecord(overrun)
+	flushOutput()
 }
 
 // Helper function that retrieves all pods and all pod metrics
 // this function creates a structured log line for each pod for which the kube metrics api provides a metric
-func collectInstanceMetrics() {
+// and returns the captured stats of all instances
+func collectInstanceMetrics(clients *KubeClients) ([]InstanceResourceStats, error) {
 
 	startTime := time.Now()
-	fmt.Println("Start to capture pod metrics ...")
+	logger.Debug("Start to capture pod metrics ...")
 
-	config, err := rest.InClusterConfig()
+	// abort the cycle, if the Kube API does not respond in time
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+
+	// obtain the kube namespace related to this Code Engine project
+	namespaces, err := getNamespaces(ctx, clients.core)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
-	// obtain the kube namespace related to this Code Engine project
-	nsBytes, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	// fetches all pods
+	podsListStart := time.Now()
+	pods, podsPagination, err := getAllPods(ctx, clients.core, namespaces)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	namespace := string(nsBytes)
+	podsListDuration := time.Since(podsListStart)
 
-	coreClientset, err := kubernetes.NewForConfig(config)
+	// fetch all pod metrics
+	metricsListStart := time.Now()
+	podMetrics, metricsPagination, err := getAllPodMetrics(ctx, clients.metrics, namespaces)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
+	metricsListDuration := time.Since(metricsListStart)
 
-	// fetches all pods
-	pods := getAllPods(coreClientset, namespace, config)
+	// pagination may return the same pod on multiple pages, if pods are changing during the collection
+	podMetrics = deduplicatePodMetrics(podMetrics)
 
-	// fetch all pod metrics
-	podMetrics := getAllPodMetrics(namespace, config)
+	podsByName := indexPods(pods)
+
+	// Optionally add a placeholder for each pod without metrics, to make new instances visible before the metrics API sampled them
+	unmeasuredMetrics := []v1beta1.PodMetrics{}
+	if emitUnmeasured {
+		unmeasuredMetrics = getUnmeasuredPodMetrics(pods, podMetrics)
+	}
 
 	var wg sync.WaitGroup
+	var statsMutex sync.Mutex
+	instanceStats := []InstanceResourceStats{}
+	containerStats := []ContainerResourceStats{}
+	traces := []PodTrace{}
 
-	for _, metric := range podMetrics {
+	for i, metric := range append(podMetrics, unmeasuredMetrics...) {
 		wg.Add(1)
 
-		go func(podMetric v1beta1.PodMetrics) {
+		// Lookup the pod that belongs to the metric, which is shared by the trace and the stats of the instance
+		pod := podsByName[metric.Namespace+"/"+metric.Name]
+
+		go func(podMetric v1beta1.PodMetrics, measured bool, pod *v1.Pod) {
 			defer wg.Done()
 
-			// Determine the component type (either app, job, build or unknown)
-			componentType := determineComponentType(podMetric)
-
-			// Determine the component name
-			var componentName string
-			var parent string
-			switch componentType {
-			case Job:
-				if val, ok := podMetric.ObjectMeta.Labels["codeengine.cloud.ibm.com/job-definition-name"]; ok {
-					componentName = val
-				} else {
-					componentName = "standalone"
-				}
-				parent = podMetric.ObjectMeta.Labels["codeengine.cloud.ibm.com/job-run"]
-			case App:
-				componentName = podMetric.ObjectMeta.Labels["serving.knative.dev/service"]
-				parent = podMetric.ObjectMeta.Labels["serving.knative.dev/revision"]
-			case Build:
-				if val, ok := podMetric.ObjectMeta.Labels["build.shipwright.io/name"]; ok {
-					componentName = val
-				} else {
-					componentName = "standalone"
-				}
+			// Determine the component type (either app, job, build or unknown), the component name and the parent
+			componentType, componentName, parent := classifyPod(podMetric.ObjectMeta.Labels)
 
-				parent = podMetric.ObjectMeta.Labels["buildrun.shipwright.io/name"]
-			default:
-				componentName = "unknown"
+			// Determine the container that should be observed
+			userContainerName := ""
+			if pod != nil {
+				userContainerName = getUserContainerName(componentType, *pod)
 			}
 
-			// Determine the actual CPU and memory usage
-			cpuCurrent := podMetric.Containers[0].Usage.Cpu().ToDec().AsApproximateFloat64() * 1000
-			memoryCurrent := podMetric.Containers[0].Usage.Memory().ToDec().AsApproximateFloat64() / 1000 / 1000
+			// Capture the raw metrics and spec of the traced pod, before it may get skipped
+			if tracePod != "" && podMetric.Name == tracePod {
+				trace := getPodTrace(podMetric, pod, componentType, componentName, parent, userContainerName)
+
+				statsMutex.Lock()
+				traces = append(traces, trace)
+				statsMutex.Unlock()
+			}
+
+			// Skip component types that should not be observed
+			if collectedComponentTypes != nil && !collectedComponentTypes[componentType] {
+				return
+			}
+			if skipUnknown && componentType == Unknown {
+				return
+			}
+
+			// Skip pods that already completed, as their metrics are stale
+			if pod != nil && excludedPhases[string(pod.Status.Phase)] {
+				return
+			}
+
+			// The metrics API does not support arbitrary field selectors, hence skip the metrics of pods that have been filtered out
+			if pod == nil && fieldSelector != "" {
+				return
+			}
+
+			// Unless sidecars should be included, only account the usage of the user container
+			observedContainerName := ""
+			if !includeSidecars {
+				observedContainerName = userContainerName
+			}
+
+			// Determine the actual CPU and memory usage. Init containers are left out, as the limits only cover the main containers
+			cpu, memory, storage := getCpuMemoryAndStorageUsage(observedContainerName, withoutInitContainers(podMetric, pod))
+			cpuCurrent := cpu.ToDec().AsApproximateFloat64() * 1000
+			memoryCurrent := getMemoryInUnit(memory)
+
+			// The metrics API usually does not report ephemeral storage, but use it if it is available
+			storageCurrent := storage.ToDec().AsApproximateFloat64() / 1000 / 1000
 
 			stats := InstanceResourceStats{
-				Metric:        "instance-resources",
-				Name:          podMetric.Name,
-				Parent:        parent,
-				ComponentType: componentType.String(),
-				ComponentName: componentName,
+				Metric:         "instance-resources",
+				RecordIdentity: recordIdentity,
+				Namespace:      podMetric.Namespace,
+				Name:           podMetric.Name,
+				Parent:         parent,
+				ComponentType:  componentType.String(),
+				ComponentName:  componentName,
 				Cpu: ResourceStats{
-					Current: int64(cpuCurrent),
+					Current:      int64(cpuCurrent),
+					currentExact: cpuCurrent,
 				},
 				Memory: ResourceStats{
-					Current: int64(memoryCurrent),
+					Current:      int64(memoryCurrent),
+					currentExact: memoryCurrent,
 				},
+				MemoryBytes: memory.Value(),
+				EphemeralStorage: ResourceStats{
+					Current:      int64(storageCurrent),
+					currentExact: storageCurrent,
+				},
+				Timestamp: startTime.Format(time.RFC3339),
+			}
+
+			// Pass on when, and over which window, the metrics API sampled the usage
+			if !podMetric.Timestamp.IsZero() {
+				stats.SampledAt = podMetric.Timestamp.Format(time.RFC3339)
 			}
+			stats.WindowSeconds = podMetric.Window.Duration.Seconds()
+
+			// Optionally pass on the original quantities, which allow downstream tools to do precise math
+			stats.Cpu.CurrentRaw = getRawQuantity(cpu)
+			stats.Memory.CurrentRaw = getRawQuantity(memory)
+			stats.EphemeralStorage.CurrentRaw = getRawQuantity(storage)
 
 			// Gather the configured resource limits and calculate the usage (in percent)
-			pod := getPod(podMetric.Name, pods)
+			missingLimits := []string{}
 			if pod != nil {
 
-				userContainerName := getUserContainerName(componentType, *pod)
+				// pass on the pod labels that should be propagated, e.g. to attribute costs to teams
+				stats.Labels = getPropagatedLabels(pod.Labels)
 
-				// determine the actual ephemeral storage usage
-				storageCurrent := obtainDiskUsage(coreClientset, namespace, podMetric.Name, userContainerName, config)
-				stats.EphemeralStorage.Current = int64(storageCurrent)
+				// pass on where the instance is running and in which lifecycle phase it is
+				stats.NodeName = pod.Spec.NodeName
+				stats.Phase = string(pod.Status.Phase)
+
+				// pass on the QoS class, as it determines the order in which instances are evicted under node pressure
+				stats.QosClass = string(pod.Status.QOSClass)
+
+				// pass on whether the instance is restarting, e.g. due to crashes
+				stats.RestartCount, stats.LastTerminationReason = getRestartCountAndLastTerminationReason(*pod)
+
+				// pass on how long the instance exists, to reveal short-lived instances
+				if !pod.CreationTimestamp.IsZero() {
+					stats.CreationTimestamp = pod.CreationTimestamp.Format(time.RFC3339)
+					stats.AgeSeconds = int64(startTime.Sub(pod.CreationTimestamp.Time).Seconds())
+				}
+
+				// determine the actual ephemeral storage usage, by inspecting the disk usage of the container.
+				// Without a config (e.g. for injected clientsets), the container cannot be exec'ed into
+				if storageCurrent == 0 && measured && clients.config != nil {
+					storageCurrent = obtainDiskUsage(ctx, clients.core, podMetric.Namespace, podMetric.Name, userContainerName, clients.config)
+					stats.EphemeralStorage.Current = int64(storageCurrent)
+					stats.EphemeralStorage.currentExact = storageCurrent
+				}
 
 				// extract memory, cpu and ephemeral storage limits
-				cpu, memory, storage := getCpuMemoryAndStorageLimits(userContainerName, *pod)
+				cpu, memory, storage := getCpuMemoryAndStorageLimits(observedContainerName, *pod)
 
 				cpuLimit := cpu.ToDec().AsApproximateFloat64() * 1000
+				stats.Cpu.ConfiguredRaw = getRawQuantity(cpu)
+				if intended := getAnnotatedLimit(*pod, limitAnnotationCpu); intended != nil {
+					enforced := int64(cpuLimit)
+					stats.Cpu.Enforced = &enforced
+					cpuLimit = intended.ToDec().AsApproximateFloat64() * 1000
+					stats.Cpu.ConfiguredRaw = getRawQuantity(intended)
+				}
 				stats.Cpu.Configured = int64(cpuLimit)
-				stats.Cpu.Usage = int64((cpuCurrent / cpuLimit) * 100)
-
-				memoryLimit := memory.ToDec().AsApproximateFloat64() / 1000 / 1000
+				stats.Cpu.configuredExact = cpuLimit
+				stats.Cpu.Usage = getUsagePercent(cpuCurrent, cpuLimit)
+
+				memoryLimitBytes := memory.Value()
+				memoryLimit := getMemoryInUnit(memory)
+				stats.Memory.ConfiguredRaw = getRawQuantity(memory)
+				if intended := getAnnotatedLimit(*pod, limitAnnotationMemory); intended != nil {
+					enforced := int64(memoryLimit)
+					stats.Memory.Enforced = &enforced
+					memoryLimitBytes = intended.Value()
+					memoryLimit = getMemoryInUnit(intended)
+					stats.Memory.ConfiguredRaw = getRawQuantity(intended)
+				}
 				stats.Memory.Configured = int64(memoryLimit)
-				stats.Memory.Usage = int64(memoryCurrent / memoryLimit * 100)
+				stats.Memory.configuredExact = memoryLimit
+				stats.Memory.Usage = getBytesUsagePercent(stats.MemoryBytes, memoryLimitBytes)
+				stats.MemoryPressure = getMemoryPressure(stats.Memory.Usage, memoryLimit)
 
 				storageLimit := storage.ToDec().AsApproximateFloat64() / 1000 / 1000
 				stats.EphemeralStorage.Configured = int64(storageLimit)
-				stats.EphemeralStorage.Usage = int64(storageCurrent / storageLimit * 100)
+				stats.EphemeralStorage.ConfiguredRaw = getRawQuantity(storage)
+				stats.EphemeralStorage.configuredExact = storageLimit
+				stats.EphemeralStorage.Usage = getUsagePercent(storageCurrent, storageLimit)
+
+				// the metrics API does not report the GPU usage, hence only the configured amount is known
+				if gpuLimit := getGpuLimit(observedContainerName, *pod); gpuLimit > 0 {
+					stats.Gpu = &ResourceStats{
+						Current:         -1,
+						Configured:      gpuLimit,
+						Usage:           -1,
+						RequestUsage:    -1,
+						currentExact:    -1,
+						configuredExact: float64(gpuLimit),
+					}
+				}
+
+				// without limits, no usage can be calculated
+				if cpuLimit <= 0 {
+					missingLimits = append(missingLimits, "CPU")
+				}
+				if memoryLimit <= 0 {
+					missingLimits = append(missingLimits, "memory")
+				}
+
+				// extract memory, cpu and ephemeral storage requests, which may be lower than the limits
+				cpuReq, memoryReq, storageReq := getCpuMemoryAndStorageRequests(observedContainerName, *pod)
+
+				cpuRequest := cpuReq.ToDec().AsApproximateFloat64() * 1000
+				stats.Cpu.Requested = int64(cpuRequest)
+				stats.Cpu.RequestedRaw = getRawQuantity(cpuReq)
+				stats.Cpu.RequestUsage = getUsagePercent(cpuCurrent, cpuRequest)
+
+				stats.Memory.Requested = int64(getMemoryInUnit(memoryReq))
+				stats.Memory.RequestedRaw = getRawQuantity(memoryReq)
+				stats.Memory.RequestUsage = getBytesUsagePercent(stats.MemoryBytes, memoryReq.Value())
+
+				storageRequest := storageReq.ToDec().AsApproximateFloat64() / 1000 / 1000
+				stats.EphemeralStorage.Requested = int64(storageRequest)
+				stats.EphemeralStorage.RequestedRaw = getRawQuantity(storageReq)
+				stats.EphemeralStorage.RequestUsage = getUsagePercent(storageCurrent, storageRequest)
 
 			}
 
 			// Compose the log line message
-			stats.Message = "Captured metrics of " + stats.ComponentType + " instance '" + stats.Name + "': " + fmt.Sprintf("%d", stats.Cpu.Current) + "m vCPU, " + fmt.Sprintf("%d", stats.Memory.Current) + " MB memory, " + fmt.Sprintf("%d", stats.EphemeralStorage.Current) + " MB ephemeral storage"
+			stats.Message = "Captured metrics of " + stats.ComponentType + " instance '" + stats.Name + "': " + fmt.Sprintf("%d", stats.Cpu.Current) + "m vCPU, " + fmt.Sprintf("%d", stats.Memory.Current) + " " + memoryUnit.name + " memory, " + fmt.Sprintf("%d", stats.EphemeralStorage.Current) + " MB ephemeral storage"
+			if stats.Gpu != nil {
+				stats.Message += ", " + fmt.Sprintf("%d", stats.Gpu.Configured) + " GPU"
+			}
+			if len(missingLimits) > 0 {
+				stats.Message += " (no " + strings.Join(missingLimits, " and ") + " limit set)"
+			}
+			if !measured {
+				stats.Unmeasured = true
+				stats.Message = "Found " + stats.ComponentType + " instance '" + stats.Name + "', but no metrics available yet"
+			}
+
+			// Break down the usage per container, to reveal which container of a pod is consuming what
+			var containers []ContainerResourceStats
+			if containerDetail {
+				containers = getContainerStats(podMetric, pod, componentType, componentName)
+			}
 
-			// Write the stringified JSON struct and make use of IBM Cloud Logs built-in parsing mechanism,
-			// which allows to annotate log lines by providing a JSON object instead of a simple string
-			fmt.Println(ToJSONString(stats))
+			statsMutex.Lock()
+			instanceStats = append(instanceStats, stats)
+			containerStats = append(containerStats, containers...)
+			statsMutex.Unlock()
 
-		}(metric)
+		}(metric, i < len(podMetrics), pod)
 	}
 
 	wg.Wait()
 
-	fmt.Println("Captured pod metrics in " + strconv.FormatInt(time.Since(startTime).Milliseconds(), 10) + "ms")
-}
+	collectionDurationMs := time.Since(startTime).Milliseconds()
+	for i := range instanceStats {
+		instanceStats[i].CollectionDurationMs = collectionDurationMs
+	}
 
-// Helper function to determine the component type
-func determineComponentType(podMetric v1beta1.PodMetrics) ComponentType {
-	if _, ok := podMetric.ObjectMeta.Labels["buildrun.shipwright.io/name"]; ok {
-		return Build
+	for _, trace := range traces {
+		writeRecord(trace)
 	}
-	if _, ok := podMetric.ObjectMeta.Labels["serving.knative.dev/service"]; ok {
-		return App
+
+	// The instances are captured concurrently, hence bring them into a stable order, which allows to diff the output of cycles
+	sortInstanceStats(instanceStats, containerStats)
+
+	// Suppress the first cycles, as the metrics API may not have sampled all pods yet
+	if warmupCycles > 0 {
+		warmupCycles--
+		logger.Info("Warming up, skipping the emission of "+strconv.Itoa(len(instanceStats))+" instances", "remaining_warmup_cycles", warmupCycles)
+		return []InstanceResourceStats{}, nil
 	}
-	if _, ok := podMetric.ObjectMeta.Labels["codeengine.cloud.ibm.com/job-run"]; ok {
-		return Job
+
+	// Compare the usage with the one of the previous cycle
+	applyUsageDeltas(instanceStats)
+
+	// Smooth the usage over the most recent cycles
+	applyUsageAverages(instanceStats)
+
+	// Compare the limits with the peak usage observed so far
+	applyUsageHeadroom(instanceStats)
+
+	// Render the message template once all fields of the records are known, e.g. the deltas and averages
+	for i := range instanceStats {
+		instanceStats[i].Message = renderMessage(instanceStats[i])
 	}
-	return Unknown
-}
 
-// Helper function to obtain a pod by its name from a slice of pods
-func getPod(name string, pods []v1.Pod) *v1.Pod {
-	for _, pod := range pods {
-		if pod.Name == name {
-			return &pod
+	writeInstanceStats(startTime, instanceStats)
+	for _, stats := range containerStats {
+		writeRecord(stats)
+	}
+
+	// Write an alert for each instance that is close to its limits
+	alerts := getResourceAlerts(instanceStats)
+	for _, alert := range alerts {
+		writeRecord(alert)
+	}
+	if emitEvents {
+		emitAlertEvents(ctx, clients.core, alerts, podsByName)
+	}
+
+	// Write an event for each container that has been OOM killed since the previous cycle
+	for _, event := range getOOMEvents(pods) {
+		writeRecord(event)
+	}
+
+	// Write a record for each container that is in CrashLoopBackOff, even if its pod does not report metrics
+	for _, crashLoop := range getCrashLoops(pods) {
+		writeRecord(crashLoop)
+	}
+
+	// Write the usage percentiles of each component over the most recent samples. They are not subject to the
+	// rollup interval, as their samples are taken on every cycle and 'PERCENTILE_EVERY' controls how often they are written
+	for _, percentiles := range getComponentPercentiles(instanceStats) {
+		writeRecord(percentiles)
+	}
+
+	// Write the rolled-up views, which may be emitted less frequently than the instances
+	if isRollupDue(startTime) {
+		writeRollups(instanceStats, pods)
+	}
+
+	// Write the usage of each node relative to its allocatable resources. As this is optional, failures do not abort the cycle
+	if collectNodes {
+		nodeStats, err := getNodeStats(ctx, clients.core, clients.metrics)
+		if err != nil {
+			logger.Warn("Failed to collect node metrics", "error", err)
 		}
+		for _, stats := range nodeStats {
+			writeRecord(stats)
+		}
+	}
+
+	// Write the resource usage of the collector itself, to reveal whether it competes with the observed workloads
+	writeRecord(getCollectorSelfStats())
+
+	// Write the latency of the list calls, to tell apart slow APIs from a slow processing
+	latency := APILatency{
+		Metric:         "api-latency",
+		RecordIdentity: recordIdentity,
+		PodsListMs:     podsListDuration.Milliseconds(),
+		MetricsListMs:  metricsListDuration.Milliseconds(),
 	}
-	return nil
+	latency.ProcessingMs = time.Since(startTime).Milliseconds() - latency.PodsListMs - latency.MetricsListMs
+	latency.Message = "Listed pods in " + strconv.FormatInt(latency.PodsListMs, 10) + "ms and pod metrics in " + strconv.FormatInt(latency.MetricsListMs, 10) + "ms, processed them in " + strconv.FormatInt(latency.ProcessingMs, 10) + "ms"
+	writeRecord(latency)
+
+	// Emit a summary on every cycle, to clearly signal that the collection ran, even if no instances were found
+	summary := CollectionSummary{
+		Metric:         "collection-summary",
+		RecordIdentity: recordIdentity,
+		Pods:           len(pods),
+		Metrics:        len(podMetrics),
+		DurationMs:     time.Since(startTime).Milliseconds(),
+		Partial:        podsPagination.Partial || metricsPagination.Partial,
+		PodPages:       podsPagination.Pages,
+		MetricsPages:   metricsPagination.Pages,
+	}
+	summary.Message = "Captured pod metrics in " + strconv.FormatInt(summary.DurationMs, 10) + "ms"
+	if summary.Partial {
+		summary.Message += " (partial, as not all pages could be fetched)"
+	}
+	writeRecord(summary)
+	flushOutput()
+
+	return instanceStats, nil
 }
 
-// Helper function to retrieve all pods from the Kube API
-func getAllPods(coreClientset *kubernetes.Clientset, namespace string, config *rest.Config) []v1.Pod {
+// Helper function to obtain the kube namespaces that should be observed. Unless overridden by the 'NAMESPACE_SELECTOR'
+// or 'NAMESPACES' env var, this is the namespace related to this Code Engine project. It is resolved from the service account,
+// the current kubeconfig context or the 'NAMESPACE' env var, in that order
+func getNamespaces(ctx context.Context, coreClientset kubernetes.Interface) ([]string, error) {
+	if namespaceSelector != "" {
+		return getSelectedNamespaces(ctx, coreClientset)
+	}
 
-	// fetches all pods
-	pods := []v1.Pod{}
-	var podsContinueToken string
-	podsPagelimit := int64(100)
+	if value := os.Getenv("NAMESPACES"); value != "" {
+		if value == "*" {
+			return []string{metav1.NamespaceAll}, nil
+		}
+		return splitList(value), nil
+	}
+
+	nsBytes, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err == nil {
+		return []string{string(nsBytes)}, nil
+	}
+
+	if namespace := getKubeconfigNamespace(); namespace != "" {
+		return []string{namespace}, nil
+	}
+
+	if namespace := os.Getenv("NAMESPACE"); namespace != "" {
+		return []string{namespace}, nil
+	}
+
+	return nil, fmt.Errorf("failed to resolve the namespace, neither the service account namespace file, the kubeconfig context nor the NAMESPACE env var provide one: %w", err)
+}
+
+// Helper function that lists the names of all namespaces, that match the 'NAMESPACE_SELECTOR'
+func getSelectedNamespaces(ctx context.Context, coreClientset kubernetes.Interface) ([]string, error) {
+	namespaces := []string{}
+	var continueToken string
 	for {
-		podList, err := coreClientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{Limit: podsPagelimit, Continue: podsContinueToken})
+		var namespaceList *v1.NamespaceList
+		err := withAPIRetries(ctx, "List namespaces", func() error {
+			var listErr error
+			namespaceList, listErr = coreClientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{Limit: pageLimit, Continue: continueToken, LabelSelector: namespaceSelector})
+			return listErr
+		})
 		if err != nil {
-			fmt.Println("Failed to list pods" + err.Error())
-			break
+			if apierrors.IsForbidden(err) {
+				return nil, fmt.Errorf("failed to list the namespaces matching the NAMESPACE_SELECTOR '%s', the service account requires a cluster role that allows to list namespaces: %w", namespaceSelector, err)
+			}
+			return nil, fmt.Errorf("failed to list the namespaces matching the NAMESPACE_SELECTOR '%s': %w", namespaceSelector, err)
 		}
 
-		pods = append(pods, podList.Items...)
+		for _, namespace := range namespaceList.Items {
+			namespaces = append(namespaces, namespace.Name)
+		}
 
-		podsContinueToken = podList.Continue
-		if len(podsContinueToken) == 0 {
+		continueToken = namespaceList.Continue
+		if len(continueToken) == 0 {
 			break
 		}
 	}
 
-	return pods
+	if len(namespaces) == 0 {
+		logger.Warn("No namespace matches the NAMESPACE_SELECTOR", "selector", namespaceSelector)
+	}
+	return namespaces, nil
+}
+
+// Helper function that indexes the given pods by their namespace and name, which allows to look up
+// the pod of each pod metric in constant time
+func indexPods(pods []v1.Pod) map[string]*v1.Pod {
+	podsByName := make(map[string]*v1.Pod, len(pods))
+	for i := range pods {
+		podsByName[pods[i].Namespace+"/"+pods[i].Name] = &pods[i]
+	}
+	return podsByName
+}
+
+// Helper function to retrieve all pods of the given namespaces from the Kube API
+func getAllPods(ctx context.Context, coreClientset kubernetes.Interface, namespaces []string) ([]v1.Pod, Pagination, error) {
+
+	// fetches all pods
+	podsPerNamespace := make([][]v1.Pod, len(namespaces))
+	pagesPerNamespace := make([]int, len(namespaces))
+	partial, err := forEachNamespace(namespaces, pagesPerNamespace, func(i int, namespace string) error {
+		var podsContinueToken string
+		restarted := false
+		for {
+			// a failed page is retried with the same continue token, hence the pages fetched so far are kept
+			var podList *v1.PodList
+			err := withAPIRetries(ctx, "List pods", func() error {
+				var listErr error
+				podList, listErr = coreClientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{Limit: pageLimit, Continue: podsContinueToken, LabelSelector: labelSelector, FieldSelector: getPodFieldSelector()})
+				return listErr
+			})
+			if err != nil {
+				// the continue token expired (e.g. after a long stall), hence restart the listing of the namespace once
+				if apierrors.IsResourceExpired(err) && podsContinueToken != "" && !restarted {
+					logger.Warn("Continue token of the pods of namespace '"+namespace+"' expired, restarting the pagination", "error", err)
+					podsPerNamespace[i] = nil
+					pagesPerNamespace[i] = 0
+					podsContinueToken = ""
+					restarted = true
+					continue
+				}
+				if apierrors.IsBadRequest(err) && fieldSelector != "" {
+					return fmt.Errorf("failed to list pods of namespace '%s', the Kube API rejected the FIELD_SELECTOR '%s': %w", namespace, fieldSelector, err)
+				}
+				return fmt.Errorf("failed to list pods of namespace '%s': %w", namespace, err)
+			}
+
+			podsPerNamespace[i] = append(podsPerNamespace[i], podList.Items...)
+			pagesPerNamespace[i]++
+
+			podsContinueToken = podList.Continue
+			if len(podsContinueToken) == 0 {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, Pagination{}, err
+	}
+
+	pods := []v1.Pod{}
+	for _, namespacePods := range podsPerNamespace {
+		pods = append(pods, namespacePods...)
+	}
+	return pods, newPagination(pagesPerNamespace, partial), nil
+}
+
+// Outcome of paginating through a list call across all namespaces
+type Pagination struct {
+	Pages   int
+	Partial bool
+}
+
+// Helper function that sums up the pages fetched per namespace
+func newPagination(pagesPerNamespace []int, partial bool) Pagination {
+	pagination := Pagination{Partial: partial}
+	for _, pages := range pagesPerNamespace {
+		pagination.Pages += pages
+	}
+	return pagination
+}
+
+// Helper function that invokes the given function for each namespace, using at most 'maxConcurrency' goroutines.
+// A failing namespace does not abort the others. Its error is logged and only returned, if no page could be fetched at all.
+// Otherwise, it is signaled whether any namespace failed, and hence the items fetched so far are partial
+func forEachNamespace(namespaces []string, pagesPerNamespace []int, fn func(i int, namespace string) error) (bool, error) {
+	errs := make([]error, len(namespaces))
+	semaphore := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for i, namespace := range namespaces {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, namespace string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			errs[i] = fn(i, namespace)
+		}(i, namespace)
+	}
+	wg.Wait()
+
+	failed := []error{}
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 && newPagination(pagesPerNamespace, false).Pages == 0 {
+		return false, errors.Join(failed...)
+	}
+	for _, err := range failed {
+		logger.Warn("Namespace could not be fetched completely, continuing with the pages fetched so far", "error", err)
+	}
+	return len(failed) > 0, nil
+}
+
+// Helper function that returns the field selector, which restricts the listed pods to the ones configured via
+// 'FIELD_SELECTOR' and 'TARGET_POD'
+func getPodFieldSelector() string {
+	selectors := []fields.Selector{}
+	if fieldSelector != "" {
+		selectors = append(selectors, fields.ParseSelectorOrDie(fieldSelector))
+	}
+	if targetPod != "" {
+		selectors = append(selectors, fields.OneTermEqualSelector("metadata.name", targetPod))
+	}
+	return fields.AndSelectors(selectors...).String()
 }
 
 // Helper function to retrieve all pods from the Kube API
-func obtainDiskUsage(coreClientset *kubernetes.Clientset, namespace string, pod string, container string, config *rest.Config) float64 {
+func obtainDiskUsage(ctx context.Context, coreClientset kubernetes.Interface, namespace string, pod string, container string, config *rest.Config) float64 {
 	// fmt.Println("obtainDiskUsage > pod: '" + pod + "', container: '" + container + "'")
 
 	// Utilize `du -sm /` to calculate the disk usage
@@ -288,14 +1455,14 @@ func obtainDiskUsage(coreClientset *kubernetes.Clientset, namespace string, pod
 	// fmt.Println("obtainDiskUsage - URL: '" + req.URL().String() + "'")
 	exec, reqErr := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
 	if reqErr != nil {
-		fmt.Println("obtainDiskUsage of pod:" + pod + "/container:" + container + " failed POST err - " + reqErr.Error())
+		logger.Warn("obtainDiskUsage of pod:"+pod+"/container:"+container+" failed POST", "error", reqErr)
 		return float64(0)
 	}
 
 	// Open a stream and wait for the exec operation to finish
 	var outBuf bytes.Buffer
 	var errBuf bytes.Buffer
-	err := exec.StreamWithContext(context.TODO(), remotecommand.StreamOptions{
+	err := exec.StreamWithContext(ctx, remotecommand.StreamOptions{
 		Stdout: &outBuf,
 		Stderr: &errBuf,
 	})
@@ -306,7 +1473,7 @@ func obtainDiskUsage(coreClientset *kubernetes.Clientset, namespace string, pod
 
 		// Render captured system error messages, in case the stdout stream did not receive any valid content
 		if err != nil {
-			fmt.Println("obtainDiskUsage of pod:" + pod + "/container:" + container + " failed with a stream err - " + err.Error() + " - stderr: '" + errBuf.String() + "'")
+			logger.Warn("obtainDiskUsage of pod:"+pod+"/container:"+container+" failed with a stream err", "error", err, "stderr", errBuf.String())
 		}
 
 		return float64(0)
@@ -316,7 +1483,7 @@ func obtainDiskUsage(coreClientset *kubernetes.Clientset, namespace string, pod
 	// Parse the output "4000   /" by splitting the words
 	diskUsageOutput := strings.Fields(strings.TrimSuffix(diskUsageOutputStr, "\n"))
 	if len(diskUsageOutput) > 2 {
-		fmt.Println("obtainDiskUsage of pod:" + pod + "/container:" + container + " - len(diskUsageOutput): '" + strconv.Itoa(len(diskUsageOutput)) + "'")
+		logger.Warn("obtainDiskUsage of pod:"+pod+"/container:"+container+" received an unexpected output", "len(diskUsageOutput)", len(diskUsageOutput))
 		return float64(0)
 	}
 
@@ -325,41 +1492,211 @@ func obtainDiskUsage(coreClientset *kubernetes.Clientset, namespace string, pod
 	// Parse the integer string to a float64
 	ephemeralStorage, parseErr := strconv.ParseFloat(diskUsageOutput[0], 64)
 	if parseErr != nil {
-		fmt.Println("obtainDiskUsage of pod:" + pod + "/container:" + container + " failed while parsing the output '" + diskUsageOutput[0] + "' - " + parseErr.Error())
+		logger.Warn("obtainDiskUsage of pod:"+pod+"/container:"+container+" failed while parsing the output '"+diskUsageOutput[0]+"'", "error", parseErr)
 		return float64(0)
 	}
 
 	return ephemeralStorage
 }
 
-// Helper function to retrieve all pod metrics from the Kube API
-func getAllPodMetrics(namespace string, config *rest.Config) []v1beta1.PodMetrics {
-	// obtain the metrics clientset
-	metricsclientset, err := metricsv.NewForConfig(config)
+// Helper function to retrieve all pod metrics of the given namespaces from the Kube API
+func getAllPodMetrics(ctx context.Context, metricsclientset metricsv.Interface, namespaces []string) ([]v1beta1.PodMetrics, Pagination, error) {
+
+	// fetch all pod metrics
+	podMetricsPerNamespace := make([][]v1beta1.PodMetrics, len(namespaces))
+	pagesPerNamespace := make([]int, len(namespaces))
+	partial, err := forEachNamespace(namespaces, pagesPerNamespace, func(i int, namespace string) error {
+		var metricsContinueToken string
+		restarted := false
+		for {
+			// fetch all pod metrics, a failed page is retried with the same continue token
+			var podMetricsList *v1beta1.PodMetricsList
+			err := withAPIRetries(ctx, "List pod metrics", func() error {
+				var listErr error
+				podMetricsList, listErr = metricsclientset.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{Limit: pageLimit, Continue: metricsContinueToken, LabelSelector: labelSelector})
+				return listErr
+			})
+			if err != nil {
+				// the continue token expired, hence restart the listing of the namespace once
+				if apierrors.IsResourceExpired(err) && metricsContinueToken != "" && !restarted {
+					logger.Warn("Continue token of the pod metrics of namespace '"+namespace+"' expired, restarting the pagination", "error", err)
+					podMetricsPerNamespace[i] = nil
+					pagesPerNamespace[i] = 0
+					metricsContinueToken = ""
+					restarted = true
+					continue
+				}
+				// Tell apart a metrics API that is not (yet) available from other failures
+				if apierrors.IsNotFound(err) || apierrors.IsServiceUnavailable(err) {
+					return fmt.Errorf("%w: %s", errMetricsAPIUnavailable, err.Error())
+				}
+				return fmt.Errorf("failed to list pod metrics of namespace '%s': %w", namespace, err)
+			}
+			pagesPerNamespace[i]++
+
+			// Not all metrics-server versions support field selectors, hence a single pod is filtered client-side
+			for _, podMetric := range podMetricsList.Items {
+				if targetPod == "" || podMetric.Name == targetPod {
+					podMetricsPerNamespace[i] = append(podMetricsPerNamespace[i], podMetric)
+				}
+			}
+
+			metricsContinueToken = podMetricsList.Continue
+			if len(metricsContinueToken) == 0 {
+				return nil
+			}
+		}
+	})
 	if err != nil {
-		panic(err.Error())
+		return nil, Pagination{}, err
 	}
 
-	// fetch all pod metrics
 	podMetrics := []v1beta1.PodMetrics{}
-	var metricsContinueToken string
-	metricsPageLimit := int64(100)
-	for {
-		// fetch all pod metrics
-		podMetricsList, err := metricsclientset.MetricsV1beta1().PodMetricses(namespace).List(context.TODO(), metav1.ListOptions{Limit: metricsPageLimit, Continue: metricsContinueToken})
-		if err != nil {
-			fmt.Println("Failed to list pod metrics" + err.Error())
-			break
+	for _, namespaceMetrics := range podMetricsPerNamespace {
+		podMetrics = append(podMetrics, namespaceMetrics...)
+	}
+	return podMetrics, newPagination(pagesPerNamespace, partial), nil
+}
+
+// Optional keys of pod annotations, which hold the intended CPU and memory limits (e.g. '500m' or '1Gi').
+// If present, the usage is calculated against them, rather than the enforced limits
+var limitAnnotationCpu string
+var limitAnnotationMemory string
+
+// Helper function that parses the quantity of the given annotation. If the annotation is absent or invalid, nil is returned
+func getAnnotatedLimit(pod v1.Pod, annotation string) *resource.Quantity {
+	if annotation == "" {
+		return nil
+	}
+	value, ok := pod.Annotations[annotation]
+	if !ok {
+		return nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		logger.Warn("Ignoring invalid limit annotation", "namespace", pod.Namespace, "name", pod.Name, "annotation", annotation, "value", value, "error", err)
+		return nil
+	}
+	return &quantity
+}
+
+// Keys of the pod labels that are copied into each instance record
+var propagatedLabels []string
+
+// Helper function that selects the labels to propagate. Only keys that are present are included
+func getPropagatedLabels(podLabels map[string]string) map[string]string {
+	if len(propagatedLabels) == 0 {
+		return nil
+	}
+	labels := map[string]string{}
+	for _, key := range propagatedLabels {
+		if value, ok := podLabels[key]; ok {
+			labels[key] = value
 		}
-		podMetrics = append(podMetrics, podMetricsList.Items...)
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
 
-		metricsContinueToken = podMetricsList.Continue
-		if len(metricsContinueToken) == 0 {
-			break
+// Helper function that creates an empty pod metric for each pod, for which the metrics API did not report any metric
+func getUnmeasuredPodMetrics(pods []v1.Pod, podMetrics []v1beta1.PodMetrics) []v1beta1.PodMetrics {
+	measured := make(map[string]bool, len(podMetrics))
+	for _, podMetric := range podMetrics {
+		measured[podMetric.Namespace+"/"+podMetric.Name] = true
+	}
+
+	unmeasured := []v1beta1.PodMetrics{}
+	for _, pod := range pods {
+		if measured[pod.Namespace+"/"+pod.Name] {
+			continue
 		}
+		unmeasured = append(unmeasured, v1beta1.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name, Labels: pod.Labels},
+		})
 	}
+	return unmeasured
+}
 
-	return podMetrics
+// Helper function that removes pod metrics reported for the same pod multiple times, keeping the most recent entry
+func deduplicatePodMetrics(podMetrics []v1beta1.PodMetrics) []v1beta1.PodMetrics {
+	indexByName := map[string]int{}
+	deduplicated := make([]v1beta1.PodMetrics, 0, len(podMetrics))
+	for _, podMetric := range podMetrics {
+		key := podMetric.Namespace + "/" + podMetric.Name
+		if i, ok := indexByName[key]; ok {
+			if podMetric.Timestamp.After(deduplicated[i].Timestamp.Time) {
+				deduplicated[i] = podMetric
+			}
+			continue
+		}
+		indexByName[key] = len(deduplicated)
+		deduplicated = append(deduplicated, podMetric)
+	}
+	return deduplicated
+}
+
+// Helper function that invokes the given function until it succeeds or the retries are exhausted.
+// The delay between two attempts starts at 'retryBaseMs' and doubles with each retry.
+// Retries stop as soon as the given context is done
+func withRetries(ctx context.Context, name string, fn func() error) error {
+	return retryWhile(ctx, name, func(error) bool { return true }, fn)
+}
+
+// Helper function that invokes the given Kube API call like 'withRetries', but only retries transient errors
+func withAPIRetries(ctx context.Context, name string, fn func() error) error {
+	return retryWhile(ctx, name, isRetriableError, fn)
+}
+
+// Helper function that retries the given function with exponential backoff, as long as its error is retriable
+func retryWhile(ctx context.Context, name string, retriable func(error) bool, fn func() error) error {
+	err := fn()
+	for attempt := 0; err != nil && retriable(err) && attempt < maxRetries; attempt++ {
+		delay := time.Duration(retryBaseMs<<attempt) * time.Millisecond
+		logger.Warn(name+" failed, retrying in "+delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		err = fn()
+	}
+	return err
+}
+
+// Helper function to check whether the given error is transient, i.e. a timeout, a throttled request (429) or a
+// server-side error (5xx), e.g. while the metrics-server restarts. Other errors, like missing permissions or a
+// misconfigured host, are not retried, as they would only delay reporting a misconfiguration
+func isRetriableError(err error) bool {
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) {
+		return true
+	}
+	return statusErr.Status().Code >= 500
+}
+
+// Helper function to sum up the restarts of all containers of a pod and to determine the reason of the most recent termination
+func getRestartCountAndLastTerminationReason(pod v1.Pod) (int32, string) {
+	var restartCount int32
+	var lastTermination *v1.ContainerStateTerminated
+	for _, status := range pod.Status.ContainerStatuses {
+		restartCount += status.RestartCount
+		if terminated := status.LastTerminationState.Terminated; terminated != nil {
+			if lastTermination == nil || terminated.FinishedAt.After(lastTermination.FinishedAt.Time) {
+				lastTermination = terminated
+			}
+		}
+	}
+
+	if lastTermination == nil {
+		return restartCount, ""
+	}
+	return restartCount, lastTermination.Reason
 }
 
 // Helper function to obtain the name of the user container (that should be observed)
@@ -369,7 +1706,19 @@ func getUserContainerName(componentType ComponentType, pod v1.Pod) string {
 	}
 
 	if componentType == App {
-		return "user-container"
+		for _, container := range pod.Spec.Containers {
+			if container.Name == appContainerName {
+				return container.Name
+			}
+		}
+
+		// Fall back to the first container that is not a known sidecar, in case the user container got renamed
+		for _, container := range pod.Spec.Containers {
+			if !sidecarContainerNames[container.Name] {
+				return container.Name
+			}
+		}
+		return pod.Spec.Containers[0].Name
 	}
 
 	if componentType == Job || componentType == Build {
@@ -379,23 +1728,141 @@ func getUserContainerName(componentType ComponentType, pod v1.Pod) string {
 	return ""
 }
 
-// Helper function to extract CPU and Memory limits from the pod spec
+// Helper function to sum up the CPU, memory and ephemeral storage usage of the containers of a pod metric.
+// If a container name is passed, only the usage of that container is taken into account
+func getCpuMemoryAndStorageUsage(containerName string, podMetric v1beta1.PodMetrics) (*resource.Quantity, *resource.Quantity, *resource.Quantity) {
+	cpuUsage := resource.NewQuantity(0, resource.DecimalSI)
+	memoryUsage := resource.NewQuantity(0, resource.BinarySI)
+	storageUsage := resource.NewQuantity(0, resource.BinarySI)
+
+	for _, container := range podMetric.Containers {
+		if len(containerName) > 0 && container.Name != containerName {
+			continue
+		}
+		cpuUsage.Add(*container.Usage.Cpu())
+		memoryUsage.Add(*container.Usage.Memory())
+		storageUsage.Add(*container.Usage.StorageEphemeral())
+	}
+
+	return cpuUsage, memoryUsage, storageUsage
+}
+
+// Helper function to extract CPU, memory and ephemeral storage limits from the pod spec.
+// If a container name is passed, only the limits of that container are taken into account,
+// otherwise the limits of all containers are summed up
 func getCpuMemoryAndStorageLimits(containerName string, pod v1.Pod) (*resource.Quantity, *resource.Quantity, *resource.Quantity) {
+	return sumContainerResources(containerName, pod, func(container v1.Container) v1.ResourceList {
+		return container.Resources.Limits
+	})
+}
+
+// Helper function to extract CPU, memory and ephemeral storage requests from the pod spec.
+// If a container name is passed, only the requests of that container are taken into account,
+// otherwise the requests of all containers are summed up
+func getCpuMemoryAndStorageRequests(containerName string, pod v1.Pod) (*resource.Quantity, *resource.Quantity, *resource.Quantity) {
+	return sumContainerResources(containerName, pod, func(container v1.Container) v1.ResourceList {
+		return container.Resources.Requests
+	})
+}
 
-	if len(containerName) == 0 {
-		return nil, nil, nil
+// Helper function that sums up CPU, memory and ephemeral storage of the resource list selected for each container
+func sumContainerResources(containerName string, pod v1.Pod, selectResources func(container v1.Container) v1.ResourceList) (*resource.Quantity, *resource.Quantity, *resource.Quantity) {
+	cpuSum := resource.NewQuantity(0, resource.DecimalSI)
+	memorySum := resource.NewQuantity(0, resource.BinarySI)
+	storageSum := resource.NewQuantity(0, resource.BinarySI)
+
+	for _, container := range pod.Spec.Containers {
+		if len(containerName) > 0 && container.Name != containerName {
+			continue
+		}
+		resources := selectResources(container)
+		cpuSum.Add(*resources.Cpu())
+		memorySum.Add(*resources.Memory())
+		storageSum.Add(*resources.StorageEphemeral())
 	}
 
+	return cpuSum, memorySum, storageSum
+}
+
+// Names of the extended resources that denote accelerators, next to those that end with '/gpu'
+var acceleratorResourceNames = map[v1.ResourceName]bool{
+	"nvidia.com/gpu":     true,
+	"amd.com/gpu":        true,
+	"gpu.intel.com/i915": true,
+	"gpu.intel.com/xe":   true,
+}
+
+// Helper function to sum up the GPUs and other accelerators configured as limits in the pod spec.
+// If a container name is passed, only the limits of that container are taken into account
+func getGpuLimit(containerName string, pod v1.Pod) int64 {
+	var gpuLimit int64
 	for _, container := range pod.Spec.Containers {
-		if container.Name == containerName {
-			cpuLimit := container.Resources.Limits.Cpu()
-			memoryLimit := container.Resources.Limits.Memory()
-			storageLimit := container.Resources.Limits.StorageEphemeral()
-			return cpuLimit, memoryLimit, storageLimit
+		if len(containerName) > 0 && container.Name != containerName {
+			continue
 		}
+		for name, quantity := range container.Resources.Limits {
+			if acceleratorResourceNames[name] || strings.HasSuffix(string(name), "/gpu") {
+				gpuLimit += quantity.Value()
+			}
+		}
+	}
+	return gpuLimit
+}
+
+// How usage percentages are converted into integers, either 'truncate', 'round' or 'ceil'
+var roundMode = "truncate"
+
+// Helper function to calculate the usage (in percent) of the given amount, rounded according to the configured mode.
+// If the amount has not been configured, the usage is reported as 0, rather than as an infinite or NaN value
+func getUsagePercent(current float64, configured float64) int64 {
+	if configured <= 0 {
+		return 0
 	}
+	percent := current / configured * 100
+	switch roundMode {
+	case "round":
+		return int64(math.Round(percent))
+	case "ceil":
+		return int64(math.Ceil(percent))
+	default:
+		return int64(percent)
+	}
+}
 
-	return nil, nil, nil
+// Helper function to calculate the usage (in percent) of the given amount of bytes, rounded according to the configured mode.
+// Integer arithmetic is used, as the float representation of large memory quantities (e.g. 64Gi) is not exact
+func getBytesUsagePercent(currentBytes int64, configuredBytes int64) int64 {
+	if configuredBytes <= 0 {
+		return 0
+	}
+	switch roundMode {
+	case "round":
+		return (currentBytes*200 + configuredBytes) / (2 * configuredBytes)
+	case "ceil":
+		return (currentBytes*100 + configuredBytes - 1) / configuredBytes
+	default:
+		return currentBytes * 100 / configuredBytes
+	}
+}
+
+// Helper function to convert the given memory quantity into the configured unit, based on its exact number of bytes
+func getMemoryInUnit(quantity *resource.Quantity) float64 {
+	return float64(quantity.Value()) / memoryUnit.divisor
+}
+
+// Helper function that classifies how close the memory usage is to the limit, at which the container gets OOM killed.
+// The metrics API reports the working set, which the kernel cannot reclaim, hence it is the figure that matters for the limit
+func getMemoryPressure(usage int64, configured float64) string {
+	if configured <= 0 {
+		return ""
+	}
+	if usage >= 90 {
+		return "high"
+	}
+	if usage >= 70 {
+		return "moderate"
+	}
+	return "low"
 }
 
 // Helper function that converts any object into a JSON string representation
@@ -404,10 +1871,39 @@ func ToJSONString(obj interface{}) string {
 		return ""
 	}
 
-	bytes, err := json.Marshal(&obj)
+	bytes, err := marshalRecord(&obj)
 	if err != nil {
 		return "marshal error"
 	}
 
 	return string(bytes)
 }
+
+// Helper function to read a boolean env var, falling back to the given default if it is not set or invalid
+func getEnvBool(name string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Helper function to read an integer env var, falling back to the given default if it is not set or invalid
+func getEnvInt(name string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Helper function to split a comma-separated list, omitting empty entries
+func splitList(value string) []string {
+	entries := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
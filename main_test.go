@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	fakemetrics "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+// Helper function that creates a pod with the given name in the given namespace
+func newTestPod(namespace string, name string) v1.Pod {
+	return v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+}
+
+// Labels of the pods of the Code Engine app 'my-app'
+var testAppLabels = map[string]string{
+	"serving.knative.dev/service":  "my-app",
+	"serving.knative.dev/revision": "my-app-00001",
+}
+
+// Helper function that creates an app pod, whose user container has the given limits
+func newTestAppPod(name string, limits v1.ResourceList) *v1.Pod {
+	pod := newTestPod("ns", name)
+	pod.Labels = testAppLabels
+	pod.Status.Phase = v1.PodRunning
+	pod.Spec.Containers = []v1.Container{
+		{Name: "user-container", Resources: v1.ResourceRequirements{Limits: limits}},
+		{Name: "queue-proxy"},
+	}
+	return &pod
+}
+
+// Helper function that creates the metric of an app pod, whose user container consumes the given CPU and memory
+func newTestAppPodMetric(name string, cpu string, memory string, timestamp time.Time) v1beta1.PodMetrics {
+	return v1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, Labels: testAppLabels},
+		Timestamp:  metav1.NewTime(timestamp),
+		Containers: []v1beta1.ContainerMetrics{
+			{Name: "user-container", Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu), v1.ResourceMemory: resource.MustParse(memory)}},
+		},
+	}
+}
+
+// Helper function that runs a single collection cycle in the namespace 'ns' against the given clientsets.
+// It returns the collected instances along with all records that have been written
+func collectTestCycle(t *testing.T, clients *KubeClients) ([]InstanceResourceStats, []string) {
+	t.Setenv("NAMESPACES", "ns")
+
+	var buf bytes.Buffer
+	stdoutWriter = bufio.NewWriter(&buf)
+	defer func() { stdoutWriter = bufio.NewWriter(os.Stdout) }()
+
+	instanceStats, err := collectInstanceMetrics(clients)
+	if err != nil {
+		t.Fatalf("collectInstanceMetrics() failed: %v", err)
+	}
+	return instanceStats, strings.Split(strings.TrimSpace(buf.String()), "\n")
+}
+
+// Helper function that returns the written records with the given metric name
+func filterTestRecords(records []string, metric string) []string {
+	filtered := []string{}
+	for _, record := range records {
+		if strings.Contains(record, `"metric":"`+metric+`"`) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+// Helper function that returns a list reactor, which responds with the given results in order, one per call.
+// A result is either a list object or an error
+func newSequenceReactor(results ...interface{}) k8stesting.ReactionFunc {
+	calls := 0
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		result := results[min(calls, len(results)-1)]
+		calls++
+		if err, ok := result.(error); ok {
+			return true, nil, err
+		}
+		return true, result.(runtime.Object), nil
+	}
+}
+
+func TestIsRetriableError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retriable bool
+	}{
+		{name: "service unavailable", err: apierrors.NewServiceUnavailable("metrics-server restarting"), retriable: true},
+		{name: "internal error", err: apierrors.NewInternalError(errors.New("boom")), retriable: true},
+		{name: "server timeout", err: apierrors.NewServerTimeout(schema.GroupResource{Resource: "pods"}, "list", 1), retriable: true},
+		{name: "too many requests", err: apierrors.NewTooManyRequests("throttled", 1), retriable: true},
+		{name: "network timeout", err: fmt.Errorf("list pods: %w", &net.DNSError{Err: "i/o timeout", IsTimeout: true}), retriable: true},
+		{name: "forbidden", err: apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", nil), retriable: false},
+		{name: "unauthorized", err: apierrors.NewUnauthorized("invalid token"), retriable: false},
+		{name: "bad request", err: apierrors.NewBadRequest("invalid field selector"), retriable: false},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "kube-api"}, retriable: false},
+	}
+
+	for _, tt := range tests {
+		if retriable := isRetriableError(tt.err); retriable != tt.retriable {
+			t.Errorf("isRetriableError(%s) = %t, want %t", tt.name, retriable, tt.retriable)
+		}
+	}
+}
+
+func TestGetAllPodsRetriesFailedMiddlePage(t *testing.T) {
+	retryBaseMs = 1
+	defer func() { retryBaseMs = 500 }()
+
+	core := fake.NewSimpleClientset()
+	core.PrependReactor("list", "pods", newSequenceReactor(
+		&v1.PodList{ListMeta: metav1.ListMeta{Continue: "page-2"}, Items: []v1.Pod{newTestPod("ns", "pod-1")}},
+		apierrors.NewServiceUnavailable("metrics-server restarting"),
+		&v1.PodList{ListMeta: metav1.ListMeta{Continue: "page-3"}, Items: []v1.Pod{newTestPod("ns", "pod-2")}},
+		&v1.PodList{Items: []v1.Pod{newTestPod("ns", "pod-3")}},
+	))
+
+	pods, pagination, err := getAllPods(context.Background(), core, []string{"ns"})
+	if err != nil {
+		t.Fatalf("getAllPods() failed: %v", err)
+	}
+	if len(pods) != 3 {
+		t.Errorf("got %d pods, want 3", len(pods))
+	}
+	if pagination.Pages != 3 || pagination.Partial {
+		t.Errorf("got %+v, want 3 complete pages", pagination)
+	}
+
+	// the failed page is retried, rather than restarting the pagination
+	if calls := len(core.Actions()); calls != 4 {
+		t.Errorf("got %d list calls, want 4", calls)
+	}
+}
+
+func TestGetAllPodsKeepsPagesOfFailedNamespace(t *testing.T) {
+	retryBaseMs = 1
+	defer func() { retryBaseMs = 500 }()
+
+	core := fake.NewSimpleClientset()
+	core.PrependReactor("list", "pods", newSequenceReactor(
+		&v1.PodList{ListMeta: metav1.ListMeta{Continue: "page-2"}, Items: []v1.Pod{newTestPod("ns", "pod-1")}},
+		apierrors.NewServiceUnavailable("metrics-server restarting"),
+	))
+
+	// the only namespace fails on page 2, which keeps the first page rather than failing the cycle
+	pods, pagination, err := getAllPods(context.Background(), core, []string{"ns"})
+	if err != nil {
+		t.Fatalf("getAllPods() failed: %v", err)
+	}
+	if len(pods) != 1 {
+		t.Errorf("got %d pods, want 1", len(pods))
+	}
+	if pagination.Pages != 1 || !pagination.Partial {
+		t.Errorf("got %+v, want 1 partial page", pagination)
+	}
+
+	// without any page fetched, the error is returned
+	core = fake.NewSimpleClientset()
+	core.PrependReactor("list", "pods", newSequenceReactor(apierrors.NewServiceUnavailable("metrics-server restarting")))
+	if _, _, err := getAllPods(context.Background(), core, []string{"ns"}); err == nil {
+		t.Errorf("getAllPods() succeeded, want an error")
+	}
+}
+
+func TestGetAllPodsRestartsOnExpiredContinueToken(t *testing.T) {
+	core := fake.NewSimpleClientset()
+	core.PrependReactor("list", "pods", newSequenceReactor(
+		&v1.PodList{ListMeta: metav1.ListMeta{Continue: "page-2"}, Items: []v1.Pod{newTestPod("ns", "pod-1")}},
+		apierrors.NewResourceExpired("continue token expired"),
+		&v1.PodList{ListMeta: metav1.ListMeta{Continue: "page-2"}, Items: []v1.Pod{newTestPod("ns", "pod-1")}},
+		&v1.PodList{Items: []v1.Pod{newTestPod("ns", "pod-2")}},
+	))
+
+	pods, pagination, err := getAllPods(context.Background(), core, []string{"ns"})
+	if err != nil {
+		t.Fatalf("getAllPods() failed: %v", err)
+	}
+	if len(pods) != 2 {
+		t.Errorf("got %d pods, want 2", len(pods))
+	}
+	if pagination.Pages != 2 {
+		t.Errorf("got %d pages, want 2", pagination.Pages)
+	}
+}
+
+func TestCollectInstanceMetricsDeduplicatesOverlappingPages(t *testing.T) {
+	now := time.Now()
+	core := fake.NewSimpleClientset(newTestAppPod("pod-a", nil), newTestAppPod("pod-b", nil), newTestAppPod("pod-c", nil))
+	metrics := fakemetrics.NewSimpleClientset()
+	metrics.PrependReactor("list", "pods", newSequenceReactor(
+		&v1beta1.PodMetricsList{ListMeta: metav1.ListMeta{Continue: "page-2"}, Items: []v1beta1.PodMetrics{
+			newTestAppPodMetric("pod-a", "100m", "100Mi", now),
+			newTestAppPodMetric("pod-b", "100m", "100Mi", now),
+		}},
+		&v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{
+			newTestAppPodMetric("pod-b", "200m", "100Mi", now.Add(time.Second)),
+			newTestAppPodMetric("pod-c", "100m", "100Mi", now),
+		}},
+	))
+
+	instanceStats, records := collectTestCycle(t, &KubeClients{core: core, metrics: metrics})
+
+	if len(instanceStats) != 3 {
+		t.Fatalf("got %d instances, want 3", len(instanceStats))
+	}
+	if written := filterTestRecords(records, "instance-resources"); len(written) != 3 {
+		t.Errorf("got %d instance records, want 3", len(written))
+	}
+	for _, stats := range instanceStats {
+		if stats.Name == "pod-b" && stats.Cpu.Current != 200 {
+			t.Errorf("got %dm vCPU for pod-b, want the most recent 200m", stats.Cpu.Current)
+		}
+	}
+}
+
+func TestCollectInstanceMetricsWithoutLimits(t *testing.T) {
+	core := fake.NewSimpleClientset(newTestAppPod("pod-a", nil))
+	metrics := fakemetrics.NewSimpleClientset()
+	metrics.PrependReactor("list", "pods", newSequenceReactor(
+		&v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{newTestAppPodMetric("pod-a", "100m", "100Mi", time.Now())}},
+	))
+
+	instanceStats, records := collectTestCycle(t, &KubeClients{core: core, metrics: metrics})
+
+	if len(instanceStats) != 1 {
+		t.Fatalf("got %d instances, want 1", len(instanceStats))
+	}
+	stats := instanceStats[0]
+	if stats.Cpu.Usage != 0 || stats.Memory.Usage != 0 || stats.Cpu.RequestUsage != 0 || stats.Memory.RequestUsage != 0 {
+		t.Errorf("got cpu %+v and memory %+v, want a usage of 0", stats.Cpu, stats.Memory)
+	}
+	if !strings.Contains(stats.Message, "(no CPU and memory limit set)") {
+		t.Errorf("got message %q, want it to note the missing limits", stats.Message)
+	}
+
+	// an infinite or NaN usage would fail to be encoded
+	written := filterTestRecords(records, "instance-resources")
+	if len(written) != 1 || !strings.Contains(written[0], `"usage":0`) {
+		t.Errorf("got instance records %v, want a single record with a usage of 0", written)
+	}
+	for _, record := range records {
+		if record == "marshal error" {
+			t.Errorf("failed to encode a record")
+		}
+	}
+}
+
+func TestGetUserContainerName(t *testing.T) {
+	tests := []struct {
+		name             string
+		appContainerName string
+		componentType    ComponentType
+		containers       []string
+		want             string
+	}{
+		{name: "default app container", appContainerName: "user-container", componentType: App, containers: []string{"queue-proxy", "user-container"}, want: "user-container"},
+		{name: "custom app container", appContainerName: "web", componentType: App, containers: []string{"queue-proxy", "istio-proxy", "web"}, want: "web"},
+		{name: "renamed app container", appContainerName: "user-container", componentType: App, containers: []string{"queue-proxy", "istio-proxy", "web"}, want: "web"},
+		{name: "only sidecars", appContainerName: "user-container", componentType: App, containers: []string{"queue-proxy", "istio-proxy"}, want: "queue-proxy"},
+		{name: "job", appContainerName: "user-container", componentType: Job, containers: []string{"my-job", "sidecar"}, want: "my-job"},
+		{name: "no containers", appContainerName: "user-container", componentType: App, containers: nil, want: ""},
+	}
+
+	defer func() { appContainerName = "user-container" }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appContainerName = tt.appContainerName
+			pod := v1.Pod{}
+			for _, name := range tt.containers {
+				pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: name})
+			}
+
+			if got := getUserContainerName(tt.componentType, pod); got != tt.want {
+				t.Errorf("getUserContainerName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetBytesUsagePercent(t *testing.T) {
+	// the previous computation, based on the approximate float representation of the quantities
+	approximateUsagePercent := func(usage resource.Quantity, limit resource.Quantity) int64 {
+		return getUsagePercent(usage.ToDec().AsApproximateFloat64()/memoryUnit.divisor, limit.ToDec().AsApproximateFloat64()/memoryUnit.divisor)
+	}
+
+	limit64Gi := resource.MustParse("64Gi")
+	limit64G := resource.MustParse("64G")
+	tests := []struct {
+		name      string
+		usage     int64
+		limit     resource.Quantity
+		roundMode string
+		want      int64
+	}{
+		{name: "64Gi half", usage: 32 * 1024 * 1024 * 1024, limit: limit64Gi, roundMode: "truncate", want: 50},
+		{name: "64Gi one byte below the limit", usage: limit64Gi.Value() - 1, limit: limit64Gi, roundMode: "truncate", want: 99},
+		{name: "64Gi one byte below the limit rounded", usage: limit64Gi.Value() - 1, limit: limit64Gi, roundMode: "round", want: 100},
+		{name: "64Gi one byte above 29% ceiled", usage: limit64Gi.Value()*29/100 + 1, limit: limit64Gi, roundMode: "ceil", want: 30},
+		{name: "64Gi exactly at the limit ceiled", usage: limit64Gi.Value(), limit: limit64Gi, roundMode: "ceil", want: 100},
+		{name: "64Gi 29.4% rounded", usage: limit64Gi.Value() * 294 / 1000, limit: limit64Gi, roundMode: "round", want: 29},
+		{name: "64Gi 29.6% rounded", usage: limit64Gi.Value() * 296 / 1000, limit: limit64Gi, roundMode: "round", want: 30},
+		{name: "64G exactly 29%", usage: 18560 * 1000 * 1000, limit: limit64G, roundMode: "truncate", want: 29},
+		{name: "no limit", usage: 1000, limit: resource.Quantity{}, roundMode: "ceil", want: 0},
+	}
+
+	defer func() { roundMode = "truncate" }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundMode = tt.roundMode
+			if got := getBytesUsagePercent(tt.usage, tt.limit.Value()); got != tt.want {
+				t.Errorf("getBytesUsagePercent() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// the approximate computation agrees for the 64Gi limit, but truncates 29% of a 64G limit to 28%
+	roundMode = "truncate"
+	usage := resource.NewQuantity(32*1024*1024*1024, resource.BinarySI)
+	if approximate, exact := approximateUsagePercent(*usage, limit64Gi), getBytesUsagePercent(usage.Value(), limit64Gi.Value()); approximate != exact {
+		t.Errorf("got %d%% with the approximate and %d%% with the exact computation for the 64Gi limit", approximate, exact)
+	}
+	usage = resource.NewQuantity(18560*1000*1000, resource.DecimalSI)
+	if approximate, exact := approximateUsagePercent(*usage, limit64G), getBytesUsagePercent(usage.Value(), limit64G.Value()); approximate != 28 || exact != 29 {
+		t.Errorf("got %d%% with the approximate and %d%% with the exact computation for the 64G limit, want 28%% and 29%%", approximate, exact)
+	}
+}
+
+// Helper function that creates the given number of pods in the namespace 'ns'
+func newTestPods(count int) []v1.Pod {
+	pods := make([]v1.Pod, count)
+	for i := range pods {
+		pods[i] = newTestPod("ns", "pod-"+strconv.Itoa(i))
+	}
+	return pods
+}
+
+const benchmarkPods = 1000
+
+func BenchmarkIndexPods(b *testing.B) {
+	pods := newTestPods(benchmarkPods)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		podsByName := indexPods(pods)
+		for i := range pods {
+			if podsByName[pods[i].Namespace+"/"+pods[i].Name] == nil {
+				b.Fatal("pod not found")
+			}
+		}
+	}
+}
+
+// Baseline of BenchmarkIndexPods, which looks up the pod of each metric by scanning all pods
+func BenchmarkLinearPodLookup(b *testing.B) {
+	pods := newTestPods(benchmarkPods)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := range pods {
+			var found *v1.Pod
+			for j := range pods {
+				if pods[j].Namespace == pods[i].Namespace && pods[j].Name == pods[i].Name {
+					found = &pods[j]
+					break
+				}
+			}
+			if found == nil {
+				b.Fatal("pod not found")
+			}
+		}
+	}
+}
+
+// Helper function that creates a fake clientset with a pod in each of the given number of namespaces
+func newTestNamespacedClientset(count int) (*fake.Clientset, []string) {
+	namespaces := []string{}
+	objects := []runtime.Object{}
+	for i := 0; i < count; i++ {
+		namespace := "ns-" + strconv.Itoa(i)
+		pod := newTestPod(namespace, "pod")
+		namespaces = append(namespaces, namespace)
+		objects = append(objects, &pod)
+	}
+	return fake.NewSimpleClientset(objects...), namespaces
+}
+
+func TestGetAllPodsContinuesAfterFailingNamespace(t *testing.T) {
+	core, namespaces := newTestNamespacedClientset(3)
+	core.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "ns-1" {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", nil)
+		}
+		return false, nil, nil
+	})
+
+	pods, pagination, err := getAllPods(context.Background(), core, namespaces)
+	if err != nil {
+		t.Fatalf("getAllPods() failed: %v", err)
+	}
+	if !pagination.Partial {
+		t.Errorf("got a complete pagination, want a partial one")
+	}
+	if len(pods) != 2 || pods[0].Namespace != "ns-0" || pods[1].Namespace != "ns-2" {
+		t.Errorf("got pods %v, want the pods of ns-0 and ns-2", pods)
+	}
+
+	// only if no page of any namespace could be fetched, the collection fails
+	core.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", nil)
+	})
+	if _, _, err := getAllPods(context.Background(), core, namespaces); err == nil {
+		t.Errorf("getAllPods() succeeded, want an error")
+	}
+}
+
+func BenchmarkForEachNamespace(b *testing.B) {
+	_, namespaces := newTestNamespacedClientset(10)
+
+	defer func() { maxConcurrency = 4 }()
+	for _, concurrency := range []int{1, 4, 10} {
+		b.Run("concurrency-"+strconv.Itoa(concurrency), func(b *testing.B) {
+			maxConcurrency = concurrency
+			for n := 0; n < b.N; n++ {
+				// simulate the latency of a list call to the Kube API, which the concurrent fetches overlap.
+				// The fake clientset serializes all calls, hence it cannot be used to measure the overlap
+				_, err := forEachNamespace(namespaces, make([]int, len(namespaces)), func(i int, namespace string) error {
+					time.Sleep(time.Millisecond)
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectInstanceMetricsWritesSummariesPerRollupInterval(t *testing.T) {
+	rollupInterval = time.Hour
+	lastRollupTime = time.Time{}
+	defer func() {
+		rollupInterval = 0
+		lastRollupTime = time.Time{}
+	}()
+
+	core := fake.NewSimpleClientset(newTestAppPod("pod-a", nil))
+	metrics := fakemetrics.NewSimpleClientset()
+	metrics.PrependReactor("list", "pods", newSequenceReactor(
+		&v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{newTestAppPodMetric("pod-a", "100m", "100Mi", time.Now())}},
+	))
+	clients := &KubeClients{core: core, metrics: metrics}
+
+	// the first cycle writes the summaries, while the next cycle within the interval only writes the instances
+	for cycle, want := range []int{1, 0} {
+		_, records := collectTestCycle(t, clients)
+		for _, metric := range []string{"cycle-top", "age-distribution", "project-total"} {
+			if got := len(filterTestRecords(records, metric)); got != want {
+				t.Errorf("got %d %s records in cycle %d, want %d", got, metric, cycle+1, want)
+			}
+		}
+		if got := len(filterTestRecords(records, "instance-resources")); got != 1 {
+			t.Errorf("got %d instance records in cycle %d, want 1", got, cycle+1)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+)
+
+// Optional template, which replaces the default message of each instance record
+var messageTemplate *template.Template
+
+// Helper function that parses the given 'text/template' of the instance message
+func parseMessageTemplate(text string) (*template.Template, error) {
+	return template.New("message").Parse(text)
+}
+
+// Helper function that renders the configured message template for the given instance.
+// If no template is configured, or rendering fails, the default message is kept
+func renderMessage(stats InstanceResourceStats) string {
+	if messageTemplate == nil {
+		return stats.Message
+	}
+
+	var sb strings.Builder
+	if err := messageTemplate.Execute(&sb, stats); err != nil {
+		logger.Warn("Failed to render the message template", "name", stats.Name, "error", err)
+		return stats.Message
+	}
+	return sb.String()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Whether the usage and allocatable resources of the cluster nodes are collected, which requires cluster-level RBAC
+var collectNodes = false
+
+type NodeResourceStats struct {
+	Metric  string        `json:"metric"`
+	Node    string        `json:"node"`
+	Cpu     ResourceStats `json:"cpu"`
+	Memory  ResourceStats `json:"memory"`
+	Message string        `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that captures the usage of each node relative to its allocatable resources.
+// If listing the nodes or their metrics is forbidden, a warning is logged and no nodes are reported
+func getNodeStats(ctx context.Context, coreClientset kubernetes.Interface, metricsclientset metricsv.Interface) ([]NodeResourceStats, error) {
+	nodeMetrics, err := metricsclientset.MetricsV1beta1().NodeMetricses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsForbidden(err) {
+			logger.Warn("Skipping node metrics, as listing them is forbidden. Collecting nodes requires a ClusterRole that grants access to 'nodes' of the 'metrics.k8s.io' API group", "error", err)
+			return []NodeResourceStats{}, nil
+		}
+		return nil, fmt.Errorf("failed to list node metrics: %w", err)
+	}
+
+	nodes, err := coreClientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsForbidden(err) {
+			logger.Warn("Skipping node metrics, as listing nodes is forbidden. Collecting nodes requires a ClusterRole that grants access to 'nodes'", "error", err)
+			return []NodeResourceStats{}, nil
+		}
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	allocatableByNode := map[string]v1.ResourceList{}
+	for _, node := range nodes.Items {
+		allocatableByNode[node.Name] = node.Status.Allocatable
+	}
+
+	nodeStats := []NodeResourceStats{}
+	for _, nodeMetric := range nodeMetrics.Items {
+		cpuCurrent := nodeMetric.Usage.Cpu().ToDec().AsApproximateFloat64() * 1000
+		memoryCurrent := getMemoryInUnit(nodeMetric.Usage.Memory())
+
+		stats := NodeResourceStats{
+			Metric:         "node-resources",
+			RecordIdentity: recordIdentity,
+			Node:           nodeMetric.Name,
+			Cpu:            ResourceStats{Current: int64(cpuCurrent), currentExact: cpuCurrent},
+			Memory:         ResourceStats{Current: int64(memoryCurrent), currentExact: memoryCurrent},
+		}
+
+		if allocatable, ok := allocatableByNode[nodeMetric.Name]; ok {
+			cpuAllocatable := allocatable.Cpu().ToDec().AsApproximateFloat64() * 1000
+			stats.Cpu.Configured = int64(cpuAllocatable)
+			stats.Cpu.configuredExact = cpuAllocatable
+			stats.Cpu.Usage = getUsagePercent(cpuCurrent, cpuAllocatable)
+
+			memoryAllocatable := getMemoryInUnit(allocatable.Memory())
+			stats.Memory.Configured = int64(memoryAllocatable)
+			stats.Memory.configuredExact = memoryAllocatable
+			stats.Memory.Usage = getBytesUsagePercent(nodeMetric.Usage.Memory().Value(), allocatable.Memory().Value())
+		}
+
+		stats.Message = "Captured metrics of node '" + stats.Node + "': " + fmt.Sprintf("%d", stats.Cpu.Current) + "m of " + fmt.Sprintf("%d", stats.Cpu.Configured) + "m vCPU, " + fmt.Sprintf("%d", stats.Memory.Current) + " of " + fmt.Sprintf("%d", stats.Memory.Configured) + " " + memoryUnit.name + " memory"
+		nodeStats = append(nodeStats, stats)
+	}
+	sort.Slice(nodeStats, func(i, j int) bool {
+		return nodeStats[i].Node < nodeStats[j].Node
+	})
+
+	return nodeStats, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+type OOMEvent struct {
+	Metric        string `json:"metric"`
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	Container     string `json:"container"`
+	Parent        string `json:"parent"`
+	ComponentType string `json:"component_type"`
+	ComponentName string `json:"component_name"`
+	MemoryLimit   int64  `json:"memory_limit"`
+	FinishedAt    string `json:"finished_at"`
+	Message       string `json:"message"`
+	RecordIdentity
+}
+
+// OOM kills that have already been reported, keyed by namespace, pod, container and termination time.
+// As the last termination state of a container persists, this avoids reporting the same kill on every cycle
+var reportedOOMKills = map[string]bool{}
+
+// Kills that finished before the collector started are not reported, as they have been reported by a previous
+// run already, or happened before the collector was deployed
+var oomKillsSince = time.Now()
+
+// Helper function that determines the containers, which have been OOM killed since the previous cycle
+func getOOMEvents(pods []v1.Pod) []OOMEvent {
+	events := []OOMEvent{}
+	seen := map[string]bool{}
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.LastTerminationState.Terminated
+			if terminated == nil || terminated.Reason != "OOMKilled" {
+				continue
+			}
+
+			key := pod.Namespace + "/" + pod.Name + "/" + status.Name + "/" + terminated.FinishedAt.String()
+			seen[key] = true
+			if reportedOOMKills[key] || terminated.FinishedAt.Time.Before(oomKillsSince) {
+				continue
+			}
+
+			componentType, componentName, parent := classifyPod(pod.Labels)
+			_, memory, _ := getCpuMemoryAndStorageLimits(status.Name, pod)
+			event := OOMEvent{
+				Metric:         "oom-event",
+				RecordIdentity: recordIdentity,
+				Namespace:      pod.Namespace,
+				Name:           pod.Name,
+				Container:      status.Name,
+				Parent:         parent,
+				ComponentType:  componentType.String(),
+				ComponentName:  componentName,
+				MemoryLimit:    int64(getMemoryInUnit(memory)),
+				FinishedAt:     terminated.FinishedAt.Format(time.RFC3339),
+			}
+			event.Message = "Container '" + event.Container + "' of " + event.ComponentType + " instance '" + event.Name + "' has been OOM killed, with a memory limit of " + fmt.Sprintf("%d", event.MemoryLimit) + " " + memoryUnit.name
+			events = append(events, event)
+		}
+	}
+
+	// Forget the kills of pods that are gone, or whose containers terminated again since
+	reportedOOMKills = seen
+	return events
+}
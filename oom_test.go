@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetOOMEventsIgnoresKillsBeforeStart(t *testing.T) {
+	reportedOOMKills = map[string]bool{}
+	defer func() { reportedOOMKills = map[string]bool{} }()
+
+	newOOMKilledPod := func(name string, finishedAt time.Time) v1.Pod {
+		pod := *newTestAppPod(name, nil)
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+			Name: "user-container",
+			LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+				Reason:     "OOMKilled",
+				FinishedAt: metav1.NewTime(finishedAt),
+			}},
+		}}
+		return pod
+	}
+	pods := []v1.Pod{
+		newOOMKilledPod("pod-before-start", oomKillsSince.Add(-time.Hour)),
+		newOOMKilledPod("pod-after-start", oomKillsSince.Add(time.Minute)),
+	}
+
+	events := getOOMEvents(pods)
+	if len(events) != 1 || events[0].Name != "pod-after-start" {
+		t.Fatalf("got events %+v, want only the kill of pod-after-start", events)
+	}
+
+	// each kill is reported once
+	if events := getOOMEvents(pods); len(events) != 0 {
+		t.Errorf("got events %+v on the second cycle, want none", events)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Exports the metrics of the most recent collection cycle to an OpenTelemetry collector via OTLP/HTTP
+type OTLPExporter struct {
+	mutex     sync.RWMutex
+	instances []InstanceResourceStats
+	provider  *sdkmetric.MeterProvider
+}
+
+// Helper function that creates an exporter, which periodically pushes the instance gauges to the given endpoint
+func newOTLPExporter(ctx context.Context, endpoint string, interval time.Duration) (*OTLPExporter, error) {
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := sdkresource.Merge(sdkresource.Default(), sdkresource.NewSchemaless(attribute.String("service.name", "metrics-collector")))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &OTLPExporter{
+		provider: sdkmetric.NewMeterProvider(
+			sdkmetric.WithResource(res),
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+		),
+	}
+
+	// Register an observable gauge for each of the gauges that are exposed to Prometheus as well
+	meter := e.provider.Meter("metrics-collector")
+	gauges := make([]metric.Int64ObservableGauge, len(instanceGauges))
+	observables := make([]metric.Observable, len(instanceGauges))
+	for i, definition := range instanceGauges {
+		gauge, err := meter.Int64ObservableGauge(definition.name, metric.WithDescription(definition.help))
+		if err != nil {
+			return nil, err
+		}
+		gauges[i] = gauge
+		observables[i] = gauge
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		e.mutex.RLock()
+		defer e.mutex.RUnlock()
+
+		for _, stats := range e.instances {
+			attributes := metric.WithAttributes(
+				attribute.String("namespace", stats.Namespace),
+				attribute.String("name", stats.Name),
+				attribute.String("parent", stats.Parent),
+				attribute.String("component_type", stats.ComponentType),
+				attribute.String("component_name", stats.ComponentName),
+			)
+			for i, definition := range instanceGauges {
+				observer.ObserveInt64(gauges[i], definition.value(stats), attributes)
+			}
+		}
+		return nil
+	}, observables...)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Write replaces the metrics that are exported with the ones of the latest collection cycle
+func (e *OTLPExporter) Write(instances []InstanceResourceStats) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.instances = instances
+}
+
+// Shutdown pushes the latest metrics one last time and stops the periodic export
+func (e *OTLPExporter) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+
+	if err := e.provider.Shutdown(ctx); err != nil {
+		logger.Warn("Failed to shut down the OTLP exporter", "error", err)
+	}
+}
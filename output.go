@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Document that contains all instances captured within a single collection cycle
+type CycleInstanceStats struct {
+	CollectedAt string                  `json:"collected_at"`
+	Instances   []InstanceResourceStats `json:"instances"`
+	RecordIdentity
+}
+
+// Identifies the collector, that produced a record, when merging the records of many projects into one store
+type RecordIdentity struct {
+	CollectorID string `json:"collector_id,omitempty"`
+	ProjectID   string `json:"project_id,omitempty"`
+}
+
+// Identity that is added to every record, configured via 'COLLECTOR_ID' and 'PROJECT_ID'
+var recordIdentity RecordIdentity
+
+// Whether records are written to stdout
+var stdoutEnabled = true
+
+// Buffers the records written to stdout, such that the lines of a cycle are emitted at once
+var stdoutWriter = bufio.NewWriter(os.Stdout)
+
+// Guards the stdout writer, which is shared with the log messages
+var stdoutMutex sync.Mutex
+
+// Optional file, to which all records are appended in addition to stdout
+var outputFile *RotatingFile
+
+// Helper function that writes the given record as JSON line to stdout (unless disabled) and, if configured, to the output file
+func writeRecord(record interface{}) {
+	writeLine(ToJSONString(record))
+}
+
+// Helper function that writes the given line to stdout (unless disabled) and, if configured, to the output file
+func writeLine(line string) {
+	if recordsSuppressed {
+		return
+	}
+
+	if stdoutEnabled {
+		stdoutMutex.Lock()
+		stdoutWriter.WriteString(line + "\n")
+		stdoutMutex.Unlock()
+	}
+
+	if outputFile != nil {
+		if err := outputFile.WriteLine(line); err != nil {
+			logger.Warn("Failed to write to output file", "error", err)
+		}
+	}
+}
+
+// Helper function that flushes all records that have been written to stdout and the output file so far
+func flushOutput() {
+	stdoutMutex.Lock()
+	err := stdoutWriter.Flush()
+	stdoutMutex.Unlock()
+	if err != nil {
+		logger.Warn("Failed to flush stdout", "error", err)
+	}
+
+	if outputFile != nil {
+		if err := outputFile.Flush(); err != nil {
+			logger.Warn("Failed to flush output file", "error", err)
+		}
+	}
+}
+
+// Columns of the CSV output. The header is written once per process start
+var csvHeader = []string{"timestamp", "name", "parent", "component_type", "component_name", "cpu_current", "cpu_configured", "cpu_usage", "mem_current", "mem_configured", "mem_usage"}
+var csvHeaderWritten = false
+
+// Helper function that writes a CSV row per instance, quoting fields where necessary
+func writeCSVRows(startTime time.Time, instanceStats []InstanceResourceStats) {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	if !csvHeaderWritten {
+		w.Write(csvHeader)
+		csvHeaderWritten = true
+	}
+	for _, stats := range instanceStats {
+		w.Write([]string{
+			startTime.Format(time.RFC3339),
+			stats.Name,
+			stats.Parent,
+			stats.ComponentType,
+			stats.ComponentName,
+			strconv.FormatInt(stats.Cpu.Current, 10),
+			strconv.FormatInt(stats.Cpu.Configured, 10),
+			strconv.FormatInt(stats.Cpu.Usage, 10),
+			strconv.FormatInt(stats.Memory.Current, 10),
+			strconv.FormatInt(stats.Memory.Configured, 10),
+			strconv.FormatInt(stats.Memory.Usage, 10),
+		})
+	}
+	w.Flush()
+
+	if sb.Len() > 0 {
+		writeLine(strings.TrimSuffix(sb.String(), "\n"))
+	}
+}
+
+// Escapes commas, equal signs and spaces in tag values of the InfluxDB line protocol
+var influxTagEscaper = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+
+// Helper function that writes a line in the InfluxDB line protocol per instance, using the cycle timestamp in nanoseconds
+func writeInfluxLines(startTime time.Time, instanceStats []InstanceResourceStats) {
+	timestamp := strconv.FormatInt(startTime.UnixNano(), 10)
+	for _, stats := range instanceStats {
+		tags := "namespace=" + influxTagEscaper.Replace(stats.Namespace) +
+			",component_type=" + influxTagEscaper.Replace(stats.ComponentType) +
+			",component_name=" + influxTagEscaper.Replace(stats.ComponentName) +
+			",name=" + influxTagEscaper.Replace(stats.Name)
+		fields := "cpu=" + strconv.FormatInt(stats.Cpu.Current, 10) + "i" +
+			",cpu_limit=" + strconv.FormatInt(stats.Cpu.Configured, 10) + "i" +
+			",cpu_usage=" + strconv.FormatInt(stats.Cpu.Usage, 10) + "i" +
+			",memory=" + strconv.FormatInt(stats.Memory.Current, 10) + "i" +
+			",memory_limit=" + strconv.FormatInt(stats.Memory.Configured, 10) + "i" +
+			",memory_usage=" + strconv.FormatInt(stats.Memory.Usage, 10) + "i"
+		writeLine("ce_instance," + tags + " " + fields + " " + timestamp)
+	}
+}
+
+// Helper function to check whether the given output mode is supported
+func isValidOutputMode(mode string) bool {
+	switch mode {
+	case "lines", "array", "csv", "influx":
+		return true
+	}
+	return false
+}
+
+// Usage floors (in percent), below which the records of idle instances are suppressed
+var minCpuPercent int64 = 0
+var minMemoryPercent int64 = 0
+
+// Helper function that drops the instances, whose usage is below all configured floors. A floor of 0 is disabled
+func filterByUsageFloor(instanceStats []InstanceResourceStats) []InstanceResourceStats {
+	if minCpuPercent <= 0 && minMemoryPercent <= 0 {
+		return instanceStats
+	}
+
+	filtered := []InstanceResourceStats{}
+	for _, stats := range instanceStats {
+		belowCpuFloor := minCpuPercent <= 0 || stats.Cpu.Usage < minCpuPercent
+		belowMemoryFloor := minMemoryPercent <= 0 || stats.Memory.Usage < minMemoryPercent
+		if !belowCpuFloor || !belowMemoryFloor {
+			filtered = append(filtered, stats)
+		}
+	}
+	return filtered
+}
+
+// Whether the record of an instance is written on every cycle ('all') or only if its usage changed ('changed')
+var emitMode = "all"
+
+// Minimum change of the CPU (in millicores) and memory usage, since the last written record of an instance, in 'changed' mode
+var changedCpuDelta int64 = 10
+var changedMemoryDelta int64 = 10
+
+// Usage of all instances, as written in their last record, keyed by namespace and instance name
+var emittedUsages = map[string]previousUsage{}
+
+// Helper function that drops the instances, whose usage did not change by the configured deltas since their last record.
+// Instances that vanished are forgotten, such that they are written again, once they reappear
+func filterByUsageChange(instanceStats []InstanceResourceStats) []InstanceResourceStats {
+	if emitMode != "changed" {
+		return instanceStats
+	}
+
+	filtered := []InstanceResourceStats{}
+	currentUsages := make(map[string]previousUsage, len(instanceStats))
+	for _, stats := range instanceStats {
+		key := stats.Namespace + "/" + stats.Name
+		emitted, ok := emittedUsages[key]
+		if ok && abs(stats.Cpu.Current-emitted.cpu) < changedCpuDelta && abs(stats.Memory.Current-emitted.memory) < changedMemoryDelta {
+			currentUsages[key] = emitted
+			continue
+		}
+		currentUsages[key] = previousUsage{cpu: stats.Cpu.Current, memory: stats.Memory.Current}
+		filtered = append(filtered, stats)
+	}
+	emittedUsages = currentUsages
+	return filtered
+}
+
+// Helper function that returns the absolute value of the given number
+func abs(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// Helper function that writes the stats of all instances of a cycle in the configured output mode.
+// Instances below the configured usage floors, or whose usage did not change in 'changed' mode, are suppressed
+func writeInstanceStats(startTime time.Time, instanceStats []InstanceResourceStats) {
+	instanceStats = filterByUsageChange(filterByUsageFloor(instanceStats))
+	switch outputMode {
+	case "array":
+		writeRecord(CycleInstanceStats{
+			CollectedAt:    startTime.Format(time.RFC3339),
+			Instances:      instanceStats,
+			RecordIdentity: recordIdentity,
+		})
+	case "csv":
+		writeCSVRows(startTime, instanceStats)
+	case "influx":
+		writeInfluxLines(startTime, instanceStats)
+	default:
+		for _, stats := range instanceStats {
+			// Write the stringified JSON struct and make use of IBM Cloud Logs built-in parsing mechanism,
+			// which allows to annotate log lines by providing a JSON object instead of a simple string
+			writeRecord(stats)
+		}
+	}
+}
+
+// Key by which the instances of a cycle are sorted, either 'name', 'cpu', 'memory' or 'component_type'
+var sortBy = "name"
+
+// Helper function that sorts the instances of a cycle by the configured key. CPU and memory are sorted in descending order,
+// such that the top consumers come first. Ties are broken by namespace and name. Containers are sorted by instance and container name
+func sortInstanceStats(instanceStats []InstanceResourceStats, containerStats []ContainerResourceStats) {
+	sort.SliceStable(instanceStats, func(i, j int) bool {
+		a, b := instanceStats[i], instanceStats[j]
+		switch sortBy {
+		case "cpu":
+			if a.Cpu.currentExact != b.Cpu.currentExact {
+				return a.Cpu.currentExact > b.Cpu.currentExact
+			}
+		case "memory":
+			if a.MemoryBytes != b.MemoryBytes {
+				return a.MemoryBytes > b.MemoryBytes
+			}
+		case "component_type":
+			if a.ComponentType != b.ComponentType {
+				return a.ComponentType < b.ComponentType
+			}
+			if a.ComponentName != b.ComponentName {
+				return a.ComponentName < b.ComponentName
+			}
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	sort.SliceStable(containerStats, func(i, j int) bool {
+		a, b := containerStats[i], containerStats[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Container < b.Container
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteLineKeepsOrderWithLogMessages(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	stdoutWriter = bufio.NewWriter(os.Stdout)
+	logLevel.Set(slog.LevelError)
+	defer func() {
+		os.Stdout = stdout
+		stdoutWriter = bufio.NewWriter(os.Stdout)
+		logLevel.Set(slog.LevelInfo)
+	}()
+
+	// records are written regardless of the log level
+	writeLine(`{"metric":"first"}`)
+	logger.Error("in between")
+	writeLine(`{"metric":"second"}`)
+	flushOutput()
+	writer.Close()
+
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "first") || !strings.Contains(lines[1], "in between") || !strings.Contains(lines[2], "second") {
+		t.Errorf("got output %q, want the records and the log message in the order they were written", output)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Number of usage samples per component, over which the percentiles are calculated. A window of 0 disables the percentiles
+var percentileWindow = 60
+
+// Number of cycles between two emissions of the percentiles
+var percentileEvery = 6
+
+type PercentileStats struct {
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+	Max int64 `json:"max"`
+}
+
+type ComponentPercentiles struct {
+	Metric        string          `json:"metric"`
+	Namespace     string          `json:"namespace"`
+	ComponentType string          `json:"component_type"`
+	ComponentName string          `json:"component_name"`
+	Samples       int             `json:"samples"`
+	CpuUsage      PercentileStats `json:"cpu_usage"`
+	MemoryUsage   PercentileStats `json:"memory_usage"`
+	Message       string          `json:"message"`
+	RecordIdentity
+}
+
+// Ring buffer that holds the most recent usage samples (in percent) of all instances of a component
+type percentileSamples struct {
+	cpu    []int64
+	memory []int64
+	next   int
+	seen   bool
+}
+
+// Adds a sample to the window, overwriting the oldest one once the window is full
+func (w *percentileSamples) add(cpu int64, memory int64) {
+	if len(w.cpu) < percentileWindow {
+		w.cpu = append(w.cpu, cpu)
+		w.memory = append(w.memory, memory)
+		return
+	}
+	w.cpu[w.next] = cpu
+	w.memory[w.next] = memory
+	w.next = (w.next + 1) % percentileWindow
+}
+
+// Sample windows of all components, keyed by namespace, component type and name
+var componentSamples = map[string]*percentileSamples{}
+
+// Number of cycles, whose samples have been added to the windows
+var percentileCycles = 0
+
+// Helper function that adds the usage of all instances to the windows of their components and, every
+// 'percentileEvery' cycles, returns the p50, p95 and max usage of each component.
+// Windows of components that vanished are dropped
+func getComponentPercentiles(instanceStats []InstanceResourceStats) []ComponentPercentiles {
+	if percentileWindow <= 0 {
+		return nil
+	}
+
+	for _, samples := range componentSamples {
+		samples.seen = false
+	}
+	for _, stats := range instanceStats {
+		key := stats.Namespace + "/" + stats.ComponentType + "/" + stats.ComponentName
+		samples, ok := componentSamples[key]
+		if !ok {
+			samples = &percentileSamples{}
+			componentSamples[key] = samples
+		}
+		samples.add(stats.Cpu.Usage, stats.Memory.Usage)
+		samples.seen = true
+	}
+	for key, samples := range componentSamples {
+		if !samples.seen {
+			delete(componentSamples, key)
+		}
+	}
+
+	percentileCycles++
+	if percentileEvery > 1 && percentileCycles%percentileEvery != 0 {
+		return nil
+	}
+
+	percentiles := []ComponentPercentiles{}
+	for _, stats := range getComponentRollups(instanceStats) {
+		samples := componentSamples[stats.Namespace+"/"+stats.ComponentType+"/"+stats.ComponentName]
+		record := ComponentPercentiles{
+			Metric:         "component-percentiles",
+			RecordIdentity: recordIdentity,
+			Namespace:      stats.Namespace,
+			ComponentType:  stats.ComponentType,
+			ComponentName:  stats.ComponentName,
+			Samples:        len(samples.cpu),
+			CpuUsage:       getPercentileStats(samples.cpu),
+			MemoryUsage:    getPercentileStats(samples.memory),
+		}
+		record.Message = "Captured usage percentiles of " + record.ComponentType + " '" + record.ComponentName + "' over " + strconv.Itoa(record.Samples) + " samples: p95 " + fmt.Sprintf("%d", record.CpuUsage.P95) + "% vCPU, p95 " + fmt.Sprintf("%d", record.MemoryUsage.P95) + "% memory"
+		percentiles = append(percentiles, record)
+	}
+	return percentiles
+}
+
+// Helper function that calculates the p50, p95 and max of the given samples, using the nearest-rank method
+func getPercentileStats(samples []int64) PercentileStats {
+	if len(samples) == 0 {
+		return PercentileStats{}
+	}
+
+	sorted := append([]int64{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p float64) int64 {
+		return sorted[int(math.Ceil(p/100*float64(len(sorted))))-1]
+	}
+	return PercentileStats{
+		P50: rank(50),
+		P95: rank(95),
+		Max: sorted[len(sorted)-1],
+	}
+}
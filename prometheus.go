@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// In-memory registry that holds the metrics of the most recent collection cycle.
+// As the registry gets replaced entirely on each update, series of vanished pods are dropped automatically
+type PrometheusRegistry struct {
+	mutex     sync.RWMutex
+	instances []InstanceResourceStats
+}
+
+// Definition of a gauge that is exposed for each instance
+type prometheusGauge struct {
+	name  string
+	help  string
+	value func(stats InstanceResourceStats) int64
+}
+
+// Number of bytes per MB. The gauges are always reported in MB, independent of the configured 'MEMORY_UNIT'
+const bytesPerMB = 1000 * 1000
+
+var instanceGauges = []prometheusGauge{
+	{"ce_instance_cpu_millicores", "Current CPU usage of the instance in millicores", func(s InstanceResourceStats) int64 { return s.Cpu.Current }},
+	{"ce_instance_cpu_limit_millicores", "Configured CPU limit of the instance in millicores", func(s InstanceResourceStats) int64 { return s.Cpu.Configured }},
+	{"ce_instance_cpu_usage_percent", "CPU usage of the instance relative to its limit", func(s InstanceResourceStats) int64 { return s.Cpu.Usage }},
+	{"ce_instance_memory_mb", "Current memory usage of the instance in MB", func(s InstanceResourceStats) int64 { return s.MemoryBytes / bytesPerMB }},
+	{"ce_instance_memory_limit_mb", "Configured memory limit of the instance in MB", getMemoryLimitMB},
+	{"ce_instance_memory_usage_percent", "Memory usage of the instance relative to its limit", func(s InstanceResourceStats) int64 { return s.Memory.Usage }},
+	{"ce_instance_ephemeral_storage_mb", "Current ephemeral storage usage of the instance in MB", func(s InstanceResourceStats) int64 { return s.EphemeralStorage.Current }},
+	{"ce_instance_ephemeral_storage_usage_percent", "Ephemeral storage usage of the instance relative to its limit", func(s InstanceResourceStats) int64 { return s.EphemeralStorage.Usage }},
+}
+
+// Helper function that converts the configured memory limit of the instance from the configured 'MEMORY_UNIT' into MB
+func getMemoryLimitMB(stats InstanceResourceStats) int64 {
+	return int64(stats.Memory.configuredExact * memoryUnit.divisor / bytesPerMB)
+}
+
+// Write replaces the metrics held by the registry with the ones of the latest collection cycle
+func (r *PrometheusRegistry) Write(instances []InstanceResourceStats) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.instances = instances
+}
+
+// ServeHTTP renders all gauges in the Prometheus text exposition format
+func (r *PrometheusRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.Write([]byte(renderPrometheusText(r.instances)))
+}
+
+// Content type of the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Helper function that renders all gauges of the given instances in the Prometheus text exposition format
+func renderPrometheusText(instances []InstanceResourceStats) string {
+	var sb strings.Builder
+	for _, gauge := range instanceGauges {
+		sb.WriteString("# HELP " + gauge.name + " " + gauge.help + "\n")
+		sb.WriteString("# TYPE " + gauge.name + " gauge\n")
+		for _, stats := range instances {
+			sb.WriteString(gauge.name + "{" + prometheusLabels(stats) + "} " + strconv.FormatInt(gauge.value(stats), 10) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// Helper function to render the identifying labels of an instance
+func prometheusLabels(stats InstanceResourceStats) string {
+	return "namespace=\"" + escapeLabelValue(stats.Namespace) + "\"," +
+		"name=\"" + escapeLabelValue(stats.Name) + "\"," +
+		"parent=\"" + escapeLabelValue(stats.Parent) + "\"," +
+		"component_type=\"" + escapeLabelValue(stats.ComponentType) + "\"," +
+		"component_name=\"" + escapeLabelValue(stats.ComponentName) + "\""
+}
+
+// Helper function to escape a label value as required by the Prometheus text format
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
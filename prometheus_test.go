@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemoryGaugesAreReportedInMB(t *testing.T) {
+	memoryUnit = memoryUnits["mib"]
+	defer func() { memoryUnit = memoryUnits["mb"] }()
+
+	// 512 MiB usage of a 1 GiB limit
+	stats := InstanceResourceStats{
+		Namespace:   "ns",
+		Name:        "pod-a",
+		MemoryBytes: 512 * 1024 * 1024,
+		Memory:      ResourceStats{Current: 512, Configured: 1024, currentExact: 512, configuredExact: 1024},
+	}
+
+	text := renderPrometheusText([]InstanceResourceStats{stats})
+	for _, want := range []string{"ce_instance_memory_mb{" + prometheusLabels(stats) + "} 536\n", "ce_instance_memory_limit_mb{" + prometheusLabels(stats) + "} 1073\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("got %q, want it to contain %q", text, want)
+		}
+	}
+
+	// the delta is reported in MB as well
+	previousUsages = map[string]previousUsage{}
+	applyUsageDeltas([]InstanceResourceStats{stats})
+	grown := stats
+	grown.MemoryBytes += 100 * 1024 * 1024
+	instanceStats := []InstanceResourceStats{grown}
+	applyUsageDeltas(instanceStats)
+	if delta := instanceStats[0].MemoryDeltaMb; delta == nil || *delta != 104 {
+		t.Errorf("got memory delta %v, want 104 MB", delta)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Helper function that pushes the gauges of all instances to a Prometheus Pushgateway.
+// Instances are grouped by component type and name, each group replaces the previously pushed metrics of that group
+func pushToPushgateway(ctx context.Context, pushgatewayURL string, job string, instanceStats []InstanceResourceStats) error {
+	groups := map[string][]InstanceResourceStats{}
+	for _, stats := range instanceStats {
+		path := "/metrics/job/" + encodeGroupingValue(job) +
+			"/component_type/" + encodeGroupingValue(stats.ComponentType) +
+			"/component_name/" + encodeGroupingValue(stats.ComponentName)
+		groups[path] = append(groups[path], stats)
+	}
+
+	for path, instances := range groups {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(pushgatewayURL, "/")+path, strings.NewReader(renderPrometheusText(instances)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", prometheusContentType)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("pushgateway responded with status %d for '%s'", resp.StatusCode, path)
+		}
+	}
+
+	logger.Info("Pushed metrics to the Pushgateway", "instances", len(instanceStats), "groups", len(groups))
+	return nil
+}
+
+// Helper function to encode a grouping key value as path segment. Values that are empty or contain a slash
+// need to be base64 encoded, as described in the Pushgateway docs
+func encodeGroupingValue(value string) string {
+	if value == "" {
+		return "@base64/="
+	}
+	if strings.Contains(value, "/") {
+		return base64.RawURLEncoding.EncodeToString([]byte(value)) + "@base64"
+	}
+	return url.PathEscape(value)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Whether the per-cycle records are suppressed, as only the capacity report is written in report mode
+var recordsSuppressed = false
+
+type CapacityReport struct {
+	Metric      string                   `json:"metric"`
+	GeneratedAt string                   `json:"generated_at"`
+	Project     ProjectTotal             `json:"project"`
+	Components  []ComponentResourceStats `json:"components"`
+	Apps        []AppServiceRollup       `json:"apps"`
+	JobRuns     []JobRunRollup           `json:"jobruns"`
+	Message     string                   `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that combines the per-component and project-total rollups of the given instances into a single document,
+// which serves scheduled reporting jobs rather than continuous monitoring
+func getCapacityReport(generatedAt time.Time, instanceStats []InstanceResourceStats) CapacityReport {
+	report := CapacityReport{
+		Metric:         "capacity-report",
+		RecordIdentity: recordIdentity,
+		GeneratedAt:    generatedAt.Format(time.RFC3339),
+		Project:        getProjectTotal(instanceStats),
+		Components:     getComponentRollups(instanceStats),
+		Apps:           getAppServiceRollups(instanceStats),
+		JobRuns:        getJobRunRollups(instanceStats),
+	}
+
+	report.Message = "Capacity report of " + strconv.Itoa(len(report.Components)) + " components (" + strconv.Itoa(report.Project.Instances) + " instances): " + fmt.Sprintf("%d", report.Project.Cpu.Current) + "m vCPU, " + fmt.Sprintf("%d", report.Project.Memory.Current) + " " + memoryUnit.name + " memory"
+	return report
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Minimum time between two emissions of the rolled-up views. An interval of 0 emits them on every cycle
+var rollupInterval time.Duration = 0
+
+// Start time of the cycle, in which the rolled-up views have been emitted the last time
+var lastRollupTime time.Time
+
+// Helper function to check whether the rolled-up views should be emitted in the cycle that started at the given time
+func isRollupDue(cycleStart time.Time) bool {
+	if rollupInterval > 0 && !lastRollupTime.IsZero() && cycleStart.Sub(lastRollupTime) < rollupInterval {
+		return false
+	}
+	lastRollupTime = cycleStart
+	return true
+}
+
+// Helper function that writes all rolled-up views of the given instances and pods
+func writeRollups(instanceStats []InstanceResourceStats, pods []v1.Pod) {
+	// Write a rolled-up view of all instances that belong to the same component
+	for _, rollup := range getComponentRollups(instanceStats) {
+		writeRecord(rollup)
+	}
+
+	// Write a rolled-up view of all revisions of each app
+	for _, rollup := range getAppServiceRollups(instanceStats) {
+		writeRecord(rollup)
+	}
+
+	// Write the number of ready and total instances of each app revision
+	for _, scaling := range getAppScaling(pods) {
+		writeRecord(scaling)
+	}
+
+	// Write a rolled-up view of all instances of each job run
+	for _, rollup := range getJobRunRollups(instanceStats) {
+		writeRecord(rollup)
+	}
+
+	// Write the total usage of all instances, broken down by component type
+	writeRecord(getProjectTotal(instanceStats))
+
+	// Write the instances with the highest usage, for an at-a-glance view of what is hot right now
+	if topK > 0 {
+		writeRecord(getCycleTop(instanceStats))
+	}
+
+	// Write how many instances are how old, which reveals the churn caused by scaling
+	writeRecord(getAgeDistribution(instanceStats))
+}
+
+type AggregatedResourceStats struct {
+	Current  int64 `json:"current"`
+	MaxUsage int64 `json:"max_usage"`
+}
+
+// Adds the usage of a single instance to the aggregation
+func (a *AggregatedResourceStats) add(stats ResourceStats) {
+	a.Current += stats.Current
+	a.MaxUsage = max(a.MaxUsage, stats.Usage)
+}
+
+type ComponentResourceStats struct {
+	Metric        string                  `json:"metric"`
+	Namespace     string                  `json:"namespace"`
+	ComponentType string                  `json:"component_type"`
+	ComponentName string                  `json:"component_name"`
+	Instances     int                     `json:"instances"`
+	Cpu           AggregatedResourceStats `json:"cpu"`
+	Memory        AggregatedResourceStats `json:"memory"`
+	Message       string                  `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that aggregates the instance stats per component (the combination of namespace, component type and name).
+// The resulting rollups are sorted by namespace, component type and name
+func getComponentRollups(instanceStats []InstanceResourceStats) []ComponentResourceStats {
+	rollupsByComponent := map[string]*ComponentResourceStats{}
+	for _, stats := range instanceStats {
+		key := stats.Namespace + "/" + stats.ComponentType + "/" + stats.ComponentName
+		rollup, ok := rollupsByComponent[key]
+		if !ok {
+			rollup = &ComponentResourceStats{
+				Metric:         "component-resources",
+				RecordIdentity: recordIdentity,
+				Namespace:      stats.Namespace,
+				ComponentType:  stats.ComponentType,
+				ComponentName:  stats.ComponentName,
+			}
+			rollupsByComponent[key] = rollup
+		}
+
+		rollup.Instances++
+		rollup.Cpu.add(stats.Cpu)
+		rollup.Memory.add(stats.Memory)
+	}
+
+	rollups := []ComponentResourceStats{}
+	for _, rollup := range rollupsByComponent {
+		rollup.Message = "Captured metrics of " + rollup.ComponentType + " '" + rollup.ComponentName + "' (" + strconv.Itoa(rollup.Instances) + " instances): " + fmt.Sprintf("%d", rollup.Cpu.Current) + "m vCPU, " + fmt.Sprintf("%d", rollup.Memory.Current) + " " + memoryUnit.name + " memory"
+		rollups = append(rollups, *rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		if rollups[i].Namespace != rollups[j].Namespace {
+			return rollups[i].Namespace < rollups[j].Namespace
+		}
+		if rollups[i].ComponentType != rollups[j].ComponentType {
+			return rollups[i].ComponentType < rollups[j].ComponentType
+		}
+		return rollups[i].ComponentName < rollups[j].ComponentName
+	})
+
+	return rollups
+}
+
+type AppServiceRollup struct {
+	Metric    string                  `json:"metric"`
+	Namespace string                  `json:"namespace"`
+	Service   string                  `json:"service"`
+	Instances int                     `json:"instances"`
+	Revisions map[string]int          `json:"revisions"`
+	Cpu       AggregatedResourceStats `json:"cpu"`
+	Memory    AggregatedResourceStats `json:"memory"`
+	Message   string                  `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that aggregates the instance stats of all revisions of each app (Knative service).
+// This reveals the total footprint of an app, while multiple revisions are running during a rollout
+func getAppServiceRollups(instanceStats []InstanceResourceStats) []AppServiceRollup {
+	rollupsByService := map[string]*AppServiceRollup{}
+	for _, stats := range instanceStats {
+		if stats.ComponentType != App.String() {
+			continue
+		}
+
+		key := stats.Namespace + "/" + stats.ComponentName
+		rollup, ok := rollupsByService[key]
+		if !ok {
+			rollup = &AppServiceRollup{
+				Metric:         "app-service-rollup",
+				RecordIdentity: recordIdentity,
+				Namespace:      stats.Namespace,
+				Service:        stats.ComponentName,
+				Revisions:      map[string]int{},
+			}
+			rollupsByService[key] = rollup
+		}
+
+		rollup.Instances++
+		rollup.Revisions[stats.Parent]++
+		rollup.Cpu.add(stats.Cpu)
+		rollup.Memory.add(stats.Memory)
+	}
+
+	rollups := []AppServiceRollup{}
+	for _, rollup := range rollupsByService {
+		rollup.Message = "Captured metrics of app '" + rollup.Service + "' (" + strconv.Itoa(rollup.Instances) + " instances in " + strconv.Itoa(len(rollup.Revisions)) + " revisions): " + fmt.Sprintf("%d", rollup.Cpu.Current) + "m vCPU, " + fmt.Sprintf("%d", rollup.Memory.Current) + " " + memoryUnit.name + " memory"
+		rollups = append(rollups, *rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		if rollups[i].Namespace != rollups[j].Namespace {
+			return rollups[i].Namespace < rollups[j].Namespace
+		}
+		return rollups[i].Service < rollups[j].Service
+	})
+
+	return rollups
+}
+
+type SpreadResourceStats struct {
+	Current int64 `json:"current"`
+	Min     int64 `json:"min"`
+	Max     int64 `json:"max"`
+}
+
+// Adds the usage of a single instance to the aggregation, while tracking the lowest and highest usage per instance
+func (a *SpreadResourceStats) add(stats ResourceStats, first bool) {
+	a.Current += stats.Current
+	if first {
+		a.Min = stats.Current
+		a.Max = stats.Current
+		return
+	}
+	a.Min = min(a.Min, stats.Current)
+	a.Max = max(a.Max, stats.Current)
+}
+
+type JobRunRollup struct {
+	Metric    string              `json:"metric"`
+	Namespace string              `json:"namespace"`
+	JobRun    string              `json:"jobrun"`
+	Job       string              `json:"job"`
+	Instances int                 `json:"instances"`
+	Cpu       SpreadResourceStats `json:"cpu"`
+	Memory    SpreadResourceStats `json:"memory"`
+	Message   string              `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that aggregates the instance stats of all (indexed) instances of each job run.
+// This reveals how the resource consumption is spread across the parallel instances of a run
+func getJobRunRollups(instanceStats []InstanceResourceStats) []JobRunRollup {
+	rollupsByJobRun := map[string]*JobRunRollup{}
+	for _, stats := range instanceStats {
+		if stats.ComponentType != Job.String() || stats.Parent == "" {
+			continue
+		}
+
+		key := stats.Namespace + "/" + stats.Parent
+		rollup, ok := rollupsByJobRun[key]
+		if !ok {
+			rollup = &JobRunRollup{
+				Metric:         "jobrun-rollup",
+				RecordIdentity: recordIdentity,
+				Namespace:      stats.Namespace,
+				JobRun:         stats.Parent,
+				Job:            stats.ComponentName,
+			}
+			rollupsByJobRun[key] = rollup
+		}
+
+		rollup.Cpu.add(stats.Cpu, rollup.Instances == 0)
+		rollup.Memory.add(stats.Memory, rollup.Instances == 0)
+		rollup.Instances++
+	}
+
+	rollups := []JobRunRollup{}
+	for _, rollup := range rollupsByJobRun {
+		rollup.Message = "Captured metrics of job run '" + rollup.JobRun + "' (" + strconv.Itoa(rollup.Instances) + " instances): " + fmt.Sprintf("%d", rollup.Cpu.Current) + "m vCPU (" + fmt.Sprintf("%d", rollup.Cpu.Min) + "-" + fmt.Sprintf("%d", rollup.Cpu.Max) + "m per instance), " + fmt.Sprintf("%d", rollup.Memory.Current) + " " + memoryUnit.name + " memory (" + fmt.Sprintf("%d", rollup.Memory.Min) + "-" + fmt.Sprintf("%d", rollup.Memory.Max) + " " + memoryUnit.name + " per instance)"
+		rollups = append(rollups, *rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		if rollups[i].Namespace != rollups[j].Namespace {
+			return rollups[i].Namespace < rollups[j].Namespace
+		}
+		return rollups[i].JobRun < rollups[j].JobRun
+	})
+
+	return rollups
+}
+
+type ComponentTypeTotal struct {
+	Instances int                     `json:"instances"`
+	Cpu       AggregatedResourceStats `json:"cpu"`
+	Memory    AggregatedResourceStats `json:"memory"`
+}
+
+type ProjectTotal struct {
+	Metric         string                         `json:"metric"`
+	Instances      int                            `json:"instances"`
+	Cpu            AggregatedResourceStats        `json:"cpu"`
+	Memory         AggregatedResourceStats        `json:"memory"`
+	ComponentTypes map[string]*ComponentTypeTotal `json:"component_types"`
+	Message        string                         `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that sums up the usage of all instances of a cycle, broken down by component type.
+// This provides a single capacity signal per cycle
+func getProjectTotal(instanceStats []InstanceResourceStats) ProjectTotal {
+	total := ProjectTotal{
+		Metric:         "project-total",
+		RecordIdentity: recordIdentity,
+		ComponentTypes: map[string]*ComponentTypeTotal{},
+	}
+	for _, stats := range instanceStats {
+		typeTotal, ok := total.ComponentTypes[stats.ComponentType]
+		if !ok {
+			typeTotal = &ComponentTypeTotal{}
+			total.ComponentTypes[stats.ComponentType] = typeTotal
+		}
+
+		total.Instances++
+		total.Cpu.add(stats.Cpu)
+		total.Memory.add(stats.Memory)
+		typeTotal.Instances++
+		typeTotal.Cpu.add(stats.Cpu)
+		typeTotal.Memory.add(stats.Memory)
+	}
+
+	total.Message = "Captured metrics of all " + strconv.Itoa(total.Instances) + " instances: " + fmt.Sprintf("%d", total.Cpu.Current) + "m vCPU, " + fmt.Sprintf("%d", total.Memory.Current) + " " + memoryUnit.name + " memory"
+	return total
+}
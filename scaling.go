@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+type AppScaling struct {
+	Metric        string `json:"metric"`
+	Namespace     string `json:"namespace"`
+	Service       string `json:"service"`
+	Revision      string `json:"revision"`
+	ReadyReplicas int    `json:"ready_replicas"`
+	TotalPods     int    `json:"total_pods"`
+	Message       string `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that counts the ready and total pods of each app revision.
+// This reveals scale-from-zero and scale-up events, during which new instances may not report metrics yet
+func getAppScaling(pods []v1.Pod) []AppScaling {
+	scalingByRevision := map[string]*AppScaling{}
+	for _, pod := range pods {
+		if excludedPhases[string(pod.Status.Phase)] {
+			continue
+		}
+		componentType, componentName, parent := classifyPod(pod.Labels)
+		if componentType != App {
+			continue
+		}
+
+		key := pod.Namespace + "/" + parent
+		scaling, ok := scalingByRevision[key]
+		if !ok {
+			scaling = &AppScaling{
+				Metric:         "app-scaling",
+				RecordIdentity: recordIdentity,
+				Namespace:      pod.Namespace,
+				Service:        componentName,
+				Revision:       parent,
+			}
+			scalingByRevision[key] = scaling
+		}
+
+		scaling.TotalPods++
+		if isPodReady(pod) {
+			scaling.ReadyReplicas++
+		}
+	}
+
+	scalings := []AppScaling{}
+	for _, scaling := range scalingByRevision {
+		scaling.Message = "Captured scaling of app '" + scaling.Service + "' revision '" + scaling.Revision + "': " + strconv.Itoa(scaling.ReadyReplicas) + " of " + strconv.Itoa(scaling.TotalPods) + " instances ready"
+		scalings = append(scalings, *scaling)
+	}
+	sort.Slice(scalings, func(i, j int) bool {
+		if scalings[i].Namespace != scalings[j].Namespace {
+			return scalings[i].Namespace < scalings[j].Namespace
+		}
+		return scalings[i].Revision < scalings[j].Revision
+	})
+
+	return scalings
+}
+
+// Helper function to check whether the Ready condition of the given pod is true
+func isPodReady(pod v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
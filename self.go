@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+type CollectorSelfStats struct {
+	Metric        string `json:"metric"`
+	CpuTimeMs     int64  `json:"cpu_time_ms"`
+	CpuMillicores int64  `json:"cpu_millicores"`
+	HeapAllocMb   int64  `json:"heap_alloc_mb"`
+	SysMb         int64  `json:"sys_mb"`
+	Goroutines    int    `json:"goroutines"`
+	Message       string `json:"message"`
+	RecordIdentity
+}
+
+// CPU time consumed by the collector process, as captured in the previous cycle
+var previousSelfCpuTime time.Duration
+var previousSelfCpuTimestamp = time.Now()
+
+// Helper function that captures the resource usage of the collector process itself.
+// The CPU usage is averaged over the time since the previous capture (or since the process start)
+func getCollectorSelfStats() CollectorSelfStats {
+	var rusage syscall.Rusage
+	var cpuTime time.Duration
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err == nil {
+		cpuTime = time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+	}
+
+	now := time.Now()
+	var cpuMillicores int64
+	if elapsed := now.Sub(previousSelfCpuTimestamp); elapsed > 0 {
+		cpuMillicores = int64(float64(cpuTime-previousSelfCpuTime) / float64(elapsed) * 1000)
+	}
+	previousSelfCpuTime = cpuTime
+	previousSelfCpuTimestamp = now
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	stats := CollectorSelfStats{
+		Metric:         "collector-self",
+		RecordIdentity: recordIdentity,
+		CpuTimeMs:      cpuTime.Milliseconds(),
+		CpuMillicores:  cpuMillicores,
+		HeapAllocMb:    int64(memStats.HeapAlloc / 1000 / 1000),
+		SysMb:          int64(memStats.Sys / 1000 / 1000),
+		Goroutines:     runtime.NumGoroutine(),
+	}
+	stats.Message = "Captured metrics of the collector itself: " + fmt.Sprintf("%d", stats.CpuMillicores) + "m vCPU, " + fmt.Sprintf("%d", stats.HeapAllocMb) + " MB heap, " + fmt.Sprintf("%d", stats.SysMb) + " MB memory obtained from the OS"
+	return stats
+}
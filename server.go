@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+)
+
+// Tracks the liveness and readiness of the daemon
+type HealthState struct {
+	mutex         sync.RWMutex
+	started       bool
+	lastSuccess   time.Time
+	maxSuccessAge time.Duration
+}
+
+// MarkStarted flags that the collection loop has been started
+func (h *HealthState) MarkStarted() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.started = true
+}
+
+// MarkSuccess records the time of the latest successful collection
+func (h *HealthState) MarkSuccess() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.lastSuccess = time.Now()
+}
+
+// Helper function that responds with 200 once the collection loop has been started
+func (h *HealthState) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if !h.started {
+		http.Error(w, "not started", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// Helper function that responds with 200 if the latest successful collection is recent enough
+func (h *HealthState) handleReadyz(w http.ResponseWriter, req *http.Request) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if h.lastSuccess.IsZero() {
+		http.Error(w, "no successful collection yet", http.StatusServiceUnavailable)
+		return
+	}
+	if age := time.Since(h.lastSuccess); age > h.maxSuccessAge {
+		http.Error(w, "last successful collection is "+age.Round(time.Second).String()+" old", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// Helper function that starts serving the /metrics, /snapshot, /healthz and /readyz endpoints in the background
+func startHTTPServer(port string, registry *PrometheusRegistry, snapshots *SnapshotStore, health *HealthState) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.Handle("/snapshot", snapshots)
+	mux.HandleFunc("/healthz", health.handleHealthz)
+	mux.HandleFunc("/readyz", health.handleReadyz)
+
+	go func() {
+		logger.Info("Serving metrics and health endpoints on port " + port + " ...")
+		if err := http.ListenAndServe(":"+port, mux); err != nil {
+			logger.Error("Failed to serve HTTP endpoints on port "+port, "error", err)
+		}
+	}()
+}
+
+// Helper function that starts serving the pprof profiles at /debug/pprof/ in the background, to diagnose the collector itself
+func startPprofServer(port string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		logger.Info("Serving pprof endpoints on port " + port + " ...")
+		if err := http.ListenAndServe(":"+port, mux); err != nil {
+			logger.Error("Failed to serve pprof endpoints on port "+port, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Optional URL of an HTTP endpoint, to which the instances of each cycle are posted as gzipped JSON document
+var sinkURL string
+
+// Timeout of a single attempt to post the instances to the sink
+var sinkTimeout = 10 * time.Second
+
+// Helper function that posts the instances of a cycle to the configured sink. Failures are logged, but do not abort the collection
+func sendToSink(instanceStats []InstanceResourceStats) {
+	if sinkURL == "" {
+		return
+	}
+
+	data, err := marshalRecord(CycleInstanceStats{
+		CollectedAt:    time.Now().Format(time.RFC3339),
+		Instances:      instanceStats,
+		RecordIdentity: recordIdentity,
+	})
+	if err != nil {
+		logger.Error("Failed to encode the instances for the sink", "error", err)
+		return
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		logger.Error("Failed to compress the instances for the sink", "error", err)
+		return
+	}
+
+	err = withRetries(context.Background(), "Post to sink", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+		defer cancel()
+		return postToSink(ctx, body.Bytes())
+	})
+	if err != nil {
+		logger.Error("Failed to post the instances to the sink", "url", sinkURL, "error", err)
+		return
+	}
+	logger.Debug("Posted metrics to the sink", "instances", len(instanceStats), "bytes", body.Len())
+}
+
+// Helper function that posts the given gzipped JSON payload to the sink
+func postToSink(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sinkURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sink responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Destination, to which the instances of each collection cycle are written.
+// The 'stdout' and 'file' outputs are no sinks, as they receive all records (e.g. alerts, rollups and summaries) in the order
+// in which they are written during the cycle, rather than only the instances. 'SINKS' enables and disables them nonetheless
+type Sink interface {
+	Write(instanceStats []InstanceResourceStats)
+}
+
+// Adapts a plain function to the Sink interface
+type sinkFunc func(instanceStats []InstanceResourceStats)
+
+func (f sinkFunc) Write(instanceStats []InstanceResourceStats) {
+	f(instanceStats)
+}
+
+// Names of all sinks that can be enabled via 'SINKS'
+var sinkNames = []string{"stdout", "file", "prometheus", "snapshot", "otlp", "http", "statsd", "cos"}
+
+// Sinks that are enabled via 'SINKS'. If nil, all configured sinks are enabled
+var enabledSinks map[string]bool
+
+// Helper function to parse the comma-separated list of sink names
+func parseSinks(value string) (map[string]bool, error) {
+	sinks := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		valid := false
+		for _, sinkName := range sinkNames {
+			valid = valid || name == sinkName
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown sink '%s', expected one of '%s'", name, strings.Join(sinkNames, "', '"))
+		}
+		sinks[name] = true
+	}
+	return sinks, nil
+}
+
+// Helper function to check whether the sink with the given name is enabled
+func isSinkEnabled(name string) bool {
+	return enabledSinks == nil || enabledSinks[name]
+}
+
+// Helper function that writes the instances of a cycle to all given sinks
+func writeToSinks(sinks []Sink, instanceStats []InstanceResourceStats) {
+	for _, sink := range sinks {
+		sink.Write(instanceStats)
+	}
+}
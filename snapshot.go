@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Holds the instances of the most recently completed collection cycle.
+// Each cycle replaces the snapshot pointer as a whole, such that readers never observe a partially updated cycle
+type SnapshotStore struct {
+	mutex    sync.RWMutex
+	snapshot *CycleInstanceStats
+}
+
+// Write replaces the snapshot with the instances of the latest collection cycle
+func (s *SnapshotStore) Write(instances []InstanceResourceStats) {
+	snapshot := &CycleInstanceStats{
+		CollectedAt:    time.Now().Format(time.RFC3339),
+		Instances:      instances,
+		RecordIdentity: recordIdentity,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.snapshot = snapshot
+}
+
+// ServeHTTP responds with the instances of the latest collection cycle as JSON document
+func (s *SnapshotStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mutex.RLock()
+	snapshot := s.snapshot
+	s.mutex.RUnlock()
+
+	if snapshot == nil {
+		http.Error(w, "no collection completed yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	data, err := marshalRecord(snapshot)
+	if err != nil {
+		http.Error(w, "failed to encode the snapshot", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
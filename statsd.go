@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Optional address (host:port) of a StatsD / DogStatsD agent, to which the instance gauges are sent via UDP
+var statsdAddr string
+
+// Maximum size of a single UDP packet, to avoid fragmentation on common networks
+const statsdMaxPacketSize = 1432
+
+// Escapes characters that separate the parts of a DogStatsD tag
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", ":", "_", "#", "_")
+
+// Helper function that sends the CPU and memory gauges of all instances to the configured StatsD agent.
+// The lines of a cycle are batched into as few packets as possible. Failures are logged, but do not abort the collection
+func sendToStatsD(instanceStats []InstanceResourceStats) {
+	if statsdAddr == "" {
+		return
+	}
+
+	conn, err := net.Dial("udp", statsdAddr)
+	if err != nil {
+		logger.Warn("Failed to connect to the StatsD agent", "address", statsdAddr, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	packets := []string{}
+	var packet strings.Builder
+	for _, stats := range instanceStats {
+		tags := "|#component_type:" + statsdTagEscaper.Replace(stats.ComponentType) +
+			",component_name:" + statsdTagEscaper.Replace(stats.ComponentName) +
+			",parent:" + statsdTagEscaper.Replace(stats.Parent) +
+			",name:" + statsdTagEscaper.Replace(stats.Name)
+		for _, line := range []string{
+			"ce.instance.cpu:" + strconv.FormatInt(stats.Cpu.Current, 10) + "|g" + tags,
+			"ce.instance.memory:" + strconv.FormatInt(stats.Memory.Current, 10) + "|g" + tags,
+			"ce.instance.cpu_usage:" + strconv.FormatInt(stats.Cpu.Usage, 10) + "|g" + tags,
+			"ce.instance.memory_usage:" + strconv.FormatInt(stats.Memory.Usage, 10) + "|g" + tags,
+		} {
+			if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacketSize {
+				packets = append(packets, packet.String())
+				packet.Reset()
+			}
+			if packet.Len() > 0 {
+				packet.WriteString("\n")
+			}
+			packet.WriteString(line)
+		}
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.String())
+	}
+
+	failed := 0
+	for _, p := range packets {
+		if _, err := conn.Write([]byte(p)); err != nil {
+			failed++
+			logger.Debug("Failed to send StatsD packet", "address", statsdAddr, "error", err)
+		}
+	}
+	if failed > 0 {
+		logger.Warn("Failed to send all metrics to the StatsD agent", "address", statsdAddr, "failed_packets", failed, "packets", len(packets))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Number of top CPU and memory consumers reported per cycle. A value of 0 disables the record
+var topK = 3
+
+type TopConsumer struct {
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	ComponentType string `json:"component_type"`
+	ComponentName string `json:"component_name"`
+	Value         int64  `json:"value"`
+}
+
+type CycleTop struct {
+	Metric  string        `json:"metric"`
+	Cpu     []TopConsumer `json:"cpu"`
+	Memory  []TopConsumer `json:"memory"`
+	Message string        `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that determines the instances with the highest current CPU and memory usage
+func getCycleTop(instanceStats []InstanceResourceStats) CycleTop {
+	top := CycleTop{
+		Metric:         "cycle-top",
+		RecordIdentity: recordIdentity,
+		Cpu:            getTopConsumers(instanceStats, func(s InstanceResourceStats) int64 { return s.Cpu.Current }),
+		Memory:         getTopConsumers(instanceStats, func(s InstanceResourceStats) int64 { return s.Memory.Current }),
+	}
+
+	cpuNames := []string{}
+	for _, consumer := range top.Cpu {
+		cpuNames = append(cpuNames, consumer.Name+" ("+fmt.Sprintf("%d", consumer.Value)+"m)")
+	}
+	memoryNames := []string{}
+	for _, consumer := range top.Memory {
+		memoryNames = append(memoryNames, consumer.Name+" ("+fmt.Sprintf("%d", consumer.Value)+" "+memoryUnit.name+")")
+	}
+	top.Message = "Top vCPU consumers: " + strings.Join(cpuNames, ", ") + "; top memory consumers: " + strings.Join(memoryNames, ", ")
+	return top
+}
+
+// Helper function that returns the 'topK' instances with the highest value, ordered descending
+func getTopConsumers(instanceStats []InstanceResourceStats, value func(stats InstanceResourceStats) int64) []TopConsumer {
+	sorted := append([]InstanceResourceStats{}, instanceStats...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return value(sorted[i]) > value(sorted[j])
+	})
+
+	consumers := []TopConsumer{}
+	for i := 0; i < len(sorted) && i < topK; i++ {
+		consumers = append(consumers, TopConsumer{
+			Namespace:     sorted[i].Namespace,
+			Name:          sorted[i].Name,
+			ComponentType: sorted[i].ComponentType,
+			ComponentName: sorted[i].ComponentName,
+			Value:         value(sorted[i]),
+		})
+	}
+	return consumers
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// Optional name of a pod, whose raw metrics and spec are written on each cycle, to debug its classification and limits
+var tracePod string
+
+type PodTrace struct {
+	Metric        string             `json:"metric"`
+	Namespace     string             `json:"namespace"`
+	Name          string             `json:"name"`
+	ComponentType string             `json:"component_type"`
+	ComponentName string             `json:"component_name"`
+	Parent        string             `json:"parent"`
+	UserContainer string             `json:"user_container"`
+	PodMetrics    v1beta1.PodMetrics `json:"pod_metrics"`
+	Pod           *PodTraceSpec      `json:"pod"`
+	Message       string             `json:"message"`
+	RecordIdentity
+}
+
+// Fields of the pod, that determine its classification and the detected limits
+type PodTraceSpec struct {
+	Labels          map[string]string       `json:"labels"`
+	Annotations     map[string]string       `json:"annotations"`
+	OwnerReferences []metav1.OwnerReference `json:"owner_references"`
+	Phase           string                  `json:"phase"`
+	QosClass        string                  `json:"qos_class"`
+	NodeName        string                  `json:"node_name"`
+	Containers      []TracedContainer       `json:"containers"`
+	InitContainers  []TracedContainer       `json:"init_containers"`
+}
+
+type TracedContainer struct {
+	Name      string                  `json:"name"`
+	Resources v1.ResourceRequirements `json:"resources"`
+}
+
+// Helper function that captures the raw metrics of the traced pod along with the relevant fields of its spec.
+// If the pod could not be found, only the metrics are reported
+func getPodTrace(podMetric v1beta1.PodMetrics, pod *v1.Pod, componentType ComponentType, componentName string, parent string, userContainerName string) PodTrace {
+	trace := PodTrace{
+		Metric:         "pod-trace",
+		RecordIdentity: recordIdentity,
+		Namespace:      podMetric.Namespace,
+		Name:           podMetric.Name,
+		ComponentType:  componentType.String(),
+		ComponentName:  componentName,
+		Parent:         parent,
+		UserContainer:  userContainerName,
+		PodMetrics:     podMetric,
+		Message:        "Traced pod '" + podMetric.Name + "', classified as " + componentType.String() + " '" + componentName + "'",
+	}
+	if pod == nil {
+		trace.Message += ", but the pod could not be found"
+		return trace
+	}
+
+	trace.Pod = &PodTraceSpec{
+		Labels:          pod.Labels,
+		Annotations:     pod.Annotations,
+		OwnerReferences: pod.OwnerReferences,
+		Phase:           string(pod.Status.Phase),
+		QosClass:        string(pod.Status.QOSClass),
+		NodeName:        pod.Spec.NodeName,
+		Containers:      getTracedContainers(pod.Spec.Containers),
+		InitContainers:  getTracedContainers(pod.Spec.InitContainers),
+	}
+	return trace
+}
+
+// Helper function that reduces the given containers to their names and resources
+func getTracedContainers(containers []v1.Container) []TracedContainer {
+	traced := []TracedContainer{}
+	for _, container := range containers {
+		traced = append(traced, TracedContainer{Name: container.Name, Resources: container.Resources})
+	}
+	return traced
+}
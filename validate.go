@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strconv"
+)
+
+type PodValidation struct {
+	Metric        string `json:"metric"`
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	Parent        string `json:"parent"`
+	ComponentType string `json:"component_type"`
+	ComponentName string `json:"component_name"`
+	UserContainer string `json:"user_container"`
+	HasMetrics    bool   `json:"has_metrics"`
+	Message       string `json:"message"`
+	RecordIdentity
+}
+
+// Helper function that lists all pods, classifies them and checks whether the metrics API reports a metric for them.
+// In contrast to the collection, a failing metrics API does not fail the validation
+func validatePods(clients *KubeClients) error {
+	logger.Debug("Start to validate pods ...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+
+	namespaces, err := getNamespaces(ctx, clients.core)
+	if err != nil {
+		return err
+	}
+
+	pods, _, err := getAllPods(ctx, clients.core, namespaces)
+	if err != nil {
+		return err
+	}
+
+	podsWithMetrics := map[string]bool{}
+	podMetrics, _, err := getAllPodMetrics(ctx, clients.metrics, namespaces)
+	if err != nil {
+		logger.Warn("Failed to list pod metrics, validating pods without them", "error", err)
+	}
+	for _, podMetric := range podMetrics {
+		podsWithMetrics[podMetric.Namespace+"/"+podMetric.Name] = true
+	}
+
+	for _, pod := range pods {
+		componentType, componentName, parent := classifyPod(pod.ObjectMeta.Labels)
+
+		validation := PodValidation{
+			Metric:         "pod-validation",
+			RecordIdentity: recordIdentity,
+			Namespace:      pod.Namespace,
+			Name:           pod.Name,
+			Parent:         parent,
+			ComponentType:  componentType.String(),
+			ComponentName:  componentName,
+			UserContainer:  getUserContainerName(componentType, pod),
+			HasMetrics:     podsWithMetrics[pod.Namespace+"/"+pod.Name],
+		}
+		validation.Message = "Classified pod '" + validation.Name + "' as " + validation.ComponentType + " '" + validation.ComponentName + "', has metrics: " + strconv.FormatBool(validation.HasMetrics)
+		writeRecord(validation)
+	}
+	flushOutput()
+
+	logger.Info("Validated " + strconv.Itoa(len(pods)) + " pods, " + strconv.Itoa(len(podMetrics)) + " of them have metrics")
+	return nil
+}